@@ -0,0 +1,80 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// dialectorDBType return the DBType of the dialector name
+func dialectorDBType(name string) DBType {
+	switch name {
+	case "mysql":
+		return MYSQL
+	case "postgres", "postgresql":
+		return POSTGRESQL
+	case "sqlserver", "mssql":
+		return MSSQL
+	case "oracle":
+		return ORACLE
+	case "sqlite", "sqlite3":
+		return SQLITE
+	case "clickhouse":
+		return CLICKHOUSE
+	default:
+		return UNKNOWN
+	}
+}
+
+// dialectorDSN return the dsn of the dialector, the official dialectors
+// keep it in the DSN field of the dialector or its Config
+func dialectorDSN(dialector gorm.Dialector) string {
+	v := reflect.Indirect(reflect.ValueOf(dialector))
+	if dsn, ok := stringField(v, "DSN"); ok {
+		return dsn
+	}
+	config, ok := field(v, "Config")
+	if !ok || config.Kind() != reflect.Ptr || config.IsNil() {
+		return ""
+	}
+	dsn, _ := stringField(config.Elem(), "DSN")
+	return dsn
+}
+
+// field return the direct field of the struct by name, the promoted
+// fields are skipped to avoid the nil embedded pointers
+func field(v reflect.Value, name string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func stringField(v reflect.Value, name string) (string, bool) {
+	f, ok := field(v, name)
+	if !ok || f.Kind() != reflect.String {
+		return "", false
+	}
+	return f.String(), true
+}
@@ -0,0 +1,57 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// testDialector is a dialector of name keeping the dsn in the DSN field
+type testDialector struct {
+	gorm.Dialector
+
+	name string
+	DSN  string
+}
+
+func (d testDialector) Name() string {
+	return d.name
+}
+
+func TestDialectorDSN(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialector gorm.Dialector
+		want      string
+	}{
+		{name: "open", dialector: mysql.Open("user:password@tcp(mysql:3306)/db"), want: "user:password@tcp(mysql:3306)/db"},
+		{name: "config", dialector: mysql.New(mysql.Config{DSN: "user:password@tcp(mysql:3306)/db"}), want: "user:password@tcp(mysql:3306)/db"},
+		{name: "nil config", dialector: &mysql.Dialector{}, want: ""},
+		{name: "dsn field", dialector: testDialector{name: "mysql", DSN: "file:test.db"}, want: "file:test.db"},
+		{name: "pointer dsn field", dialector: &testDialector{name: "mysql", DSN: "file:test.db"}, want: "file:test.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dialectorDSN(tt.dialector); got != tt.want {
+				t.Errorf("dialectorDSN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
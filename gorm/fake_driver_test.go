@@ -0,0 +1,261 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	fakeDriverName = "go2sky-gorm-fake"
+	fakeDsn        = "user:password@tcp(mysql:3306)/db"
+)
+
+var errFake = errors.New("fake error")
+
+func init() {
+	sql.Register(fakeDriverName, &fakeDriver{})
+}
+
+// fakeDriver is a database/sql driver without database, the statements containing
+// "fail" return errFake and the queries containing "none" return no rows
+type fakeDriver struct{}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return &fakeTx{}, nil
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errFake
+	}
+	return fakeResult{}, nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errFake
+	}
+	rows := &fakeRows{}
+	if strings.Contains(s.query, "none") {
+		rows.n = 1
+	}
+	return rows, nil
+}
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// fakeRows return a row of the ids of the test models
+type fakeRows struct {
+	n int
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "user_id", "order_id"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n > 0 {
+		return io.EOF
+	}
+	r.n++
+	for i := range dest {
+		dest[i] = int64(1)
+	}
+	return nil
+}
+
+type fakeTx struct{}
+
+func (tx *fakeTx) Commit() error {
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
+
+type User struct {
+	ID     uint
+	Orders []Order
+}
+
+type Order struct {
+	ID     uint
+	UserID uint
+	Items  []Item
+}
+
+type Item struct {
+	ID      uint
+	OrderID uint
+}
+
+// mockReporter collect the reported segments
+type mockReporter struct {
+	segments chan []go2sky.ReportedSpan
+}
+
+func (r *mockReporter) Boot(string, string, []go2sky.AgentConfigChangeWatcher) {
+}
+
+func (r *mockReporter) Send(spans []go2sky.ReportedSpan) {
+	r.segments <- spans
+}
+
+func (r *mockReporter) Close() {
+}
+
+func newTestTracer(t *testing.T) (*go2sky.Tracer, *mockReporter) {
+	r := &mockReporter{segments: make(chan []go2sky.ReportedSpan, 16)}
+	tracer, err := go2sky.NewTracer("service", go2sky.WithReporter(r))
+	if err != nil {
+		t.Fatalf("new tracer error: %v", err)
+	}
+	return tracer, r
+}
+
+// openFakeDB open the mysql dialector of dsn on the fake driver and use the plugin
+func openFakeDB(t *testing.T, tracer *go2sky.Tracer, dsn string, opts ...Option) *gorm.DB {
+	return openFakeDBWithConfig(t, tracer, dsn, &gorm.Config{}, opts...)
+}
+
+func openFakeDBWithConfig(t *testing.T, tracer *go2sky.Tracer, dsn string, config *gorm.Config, opts ...Option) *gorm.DB {
+	sqlDB, err := sql.Open(fakeDriverName, "")
+	if err != nil {
+		t.Fatalf("open sql db error: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	config.Logger = logger.Discard
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       dsn,
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), config)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	if err := db.Use(New(tracer, opts...)); err != nil {
+		t.Fatalf("use plugin error: %v", err)
+	}
+	return db
+}
+
+// trace run fn in a root span and return the reported spans except the root,
+// in the order of creating
+func trace(t *testing.T, tracer *go2sky.Tracer, r *mockReporter, fn func(ctx context.Context)) []go2sky.ReportedSpan {
+	t.Helper()
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("root"))
+	if err != nil {
+		t.Fatalf("create root span error: %v", err)
+	}
+	fn(ctx)
+	span.End()
+	select {
+	case spans := <-r.segments:
+		return sortSpans(spans[:len(spans)-1])
+	case <-time.After(time.Second):
+		t.Fatal("segment is not reported")
+		return nil
+	}
+}
+
+// sortSpans sort the spans by span id, which is the order of creating
+func sortSpans(spans []go2sky.ReportedSpan) []go2sky.ReportedSpan {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Context().SpanID < spans[j].Context().SpanID
+	})
+	return spans
+}
+
+func findSpan(spans []go2sky.ReportedSpan, operationName string) go2sky.ReportedSpan {
+	for _, s := range spans {
+		if s.OperationName() == operationName {
+			return s
+		}
+	}
+	return nil
+}
+
+func spanNames(spans []go2sky.ReportedSpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, s := range spans {
+		names = append(names, s.OperationName())
+	}
+	return names
+}
+
+func spanTags(span go2sky.ReportedSpan) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range span.Tags() {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
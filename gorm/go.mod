@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/SkyAPM/go2sky v1.5.0
+	github.com/SkyAPM/go2sky-plugins/sql v0.0.0-00010101000000-000000000000
 	gorm.io/gorm v1.22.3
 	skywalking.apache.org/repo/goapi v0.0.0-20220401015832-2c9eee9481eb
 )
@@ -17,3 +18,5 @@ require (
 	google.golang.org/grpc v1.42.0 // indirect
 	gorm.io/driver/mysql v1.2.0
 )
+
+replace github.com/SkyAPM/go2sky-plugins/sql => ../sql
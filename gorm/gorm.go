@@ -17,11 +17,15 @@
 package gorm
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/SkyAPM/go2sky"
+	sqlplugin "github.com/SkyAPM/go2sky-plugins/sql"
 	"gorm.io/gorm"
 	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
 )
@@ -30,7 +34,20 @@ var (
 	_ gorm.Plugin = &SkyWalking{}
 )
 
-const spanKey = "spanKey"
+const (
+	spanKey = "spanKey"
+	ctxKey  = "ctxKey"
+)
+
+const (
+	tagDBName         go2sky.Tag = "db.name"
+	tagDBRowsAffected go2sky.Tag = "db.rows_affected"
+	tagGormModel      go2sky.Tag = "gorm.model"
+	tagGormPreload    go2sky.Tag = "gorm.preload"
+)
+
+// preloadKey mark the context of the query with preloads
+type preloadKey struct{}
 
 type SkyWalking struct {
 	tracer *go2sky.Tracer
@@ -40,8 +57,8 @@ type SkyWalking struct {
 func New(tracer *go2sky.Tracer, opts ...Option) *SkyWalking {
 	options := &options{
 		dbType:      UNKNOWN,
-		componentID: componentIDUnknown,
-		peer:        "unknown",
+		componentID: sqlplugin.ComponentID(UNKNOWN),
+		peer:        defaultPeer,
 		reportQuery: false,
 		reportParam: false,
 	}
@@ -61,6 +78,11 @@ func (s *SkyWalking) Name() string {
 }
 
 func (s *SkyWalking) Initialize(db *gorm.DB) (err error) {
+	s.opts.detect(db.Dialector)
+	if s.opts.traceTx && s.tracer != nil {
+		s.wrapConnPool(db)
+	}
+
 	// before database operation
 	db.Callback().Create().Before("gorm:create").Register("sky_create_span", s.BeforeCallback("create"))
 	db.Callback().Query().Before("gorm:query").Register("sky_create_span", s.BeforeCallback("query"))
@@ -71,7 +93,12 @@ func (s *SkyWalking) Initialize(db *gorm.DB) (err error) {
 
 	// after database operation
 	db.Callback().Create().After("gorm:create").Register("sky_end_span", s.AfterCallback())
-	db.Callback().Query().After("gorm:query").Register("sky_end_span", s.AfterCallback())
+	if s.opts.tracePreload {
+		// the span of query ends after the preload queries
+		db.Callback().Query().After("gorm:preload").Register("sky_end_span", s.AfterCallback())
+	} else {
+		db.Callback().Query().After("gorm:query").Register("sky_end_span", s.AfterCallback())
+	}
 	db.Callback().Update().After("gorm:update").Register("sky_end_span", s.AfterCallback())
 	db.Callback().Delete().After("gorm:delete").Register("sky_end_span", s.AfterCallback())
 	db.Callback().Row().After("gorm:row").Register("sky_end_span", s.AfterCallback())
@@ -89,19 +116,76 @@ func (s *SkyWalking) BeforeCallback(operation string) func(db *gorm.DB) {
 	}
 
 	return func(db *gorm.DB) {
-		tableName := db.Statement.Table
-		operation := fmt.Sprintf("%s/%s", tableName, operation)
+		ctx := db.Statement.Context
+		tx, inTx := db.Statement.ConnPool.(*txConnPool)
+		if inTx {
+			ctx = tx.spanContext(ctx)
+		}
 
-		span, err := tracer.CreateExitSpan(db.Statement.Context, operation, peer, func(key, value string) error {
-			return nil
-		})
+		op := operation
+		if operation == "query" && ctx.Value(preloadKey{}) != nil {
+			// the query is issued by the Preload of the parent query
+			op = "preload"
+		}
+		operationName := s.opts.getOpName(db.Statement, op)
+		rollback := false
+		if operation == "raw" || operation == "row" {
+			sql := db.Statement.SQL.String()
+			if op := savepointOperation(sql); op != "" && inTx {
+				operationName = s.opts.prefix("transaction/" + op)
+				rollback = op == "rollback_to_savepoint"
+			} else if op := migrationOperation(sql); op != "" && s.opts.traceMigration {
+				operationName = s.opts.prefix("migrate/" + op)
+			}
+		}
+
+		var span go2sky.Span
+		var err error
+		preloads := s.opts.tracePreload && operation == "query" && len(db.Statement.Preloads) > 0
+		if preloads {
+			// the preload queries are the children of the span
+			span, _, err = tracer.CreateLocalSpan(ctx,
+				go2sky.WithSpanType(go2sky.SpanTypeExit),
+				go2sky.WithOperationName(operationName),
+			)
+			if err == nil {
+				span.SetPeer(peer)
+			}
+		} else {
+			span, err = tracer.CreateExitSpan(ctx, operationName, peer, func(key, value string) error {
+				return nil
+			})
+		}
 		if err != nil {
 			db.Logger.Error(db.Statement.Context, "gorm:skyWalking failed to create exit span, got error: %v", err)
 			return
 		}
 
-		// set span from db instance's context to pass span
-		db.Set(spanKey, span)
+		span.SetComponent(s.opts.componentID)
+		span.SetSpanLayer(agentv3.SpanLayer_Database)
+		s.tagDB(span)
+		if s.opts.reportModel {
+			if model := modelName(db.Statement); model != "" {
+				span.Tag(tagGormModel, model)
+			}
+			if len(db.Statement.Preloads) > 0 {
+				span.Tag(tagGormPreload, preloadNames(db.Statement.Preloads))
+			}
+		}
+		if rollback {
+			span.Error(time.Now(), "rollback to savepoint")
+		}
+
+		if preloads {
+			// the preload queries share the context of the statement, only the span is
+			// carried so they keep its deadline and values, it is restored when the span ends
+			db.InstanceSet(ctxKey, db.Statement.Context)
+			db.Statement.Context = context.WithValue(go2sky.WithSpan(db.Statement.Context, span), preloadKey{}, true)
+		}
+
+		// set span from db instance's context to pass span, the settings
+		// are copied to the preload queries, so the instance is used
+		db.InstanceSet(spanKey, span)
 	}
 }
 
@@ -113,7 +197,7 @@ func (s *SkyWalking) AfterCallback() func(db *gorm.DB) {
 
 	return func(db *gorm.DB) {
 		// get span from db instance's context
-		spanInterface, _ := db.Get(spanKey)
+		spanInterface, _ := db.InstanceGet(spanKey)
 		span, ok := spanInterface.(go2sky.Span)
 		if !ok {
 			return
@@ -121,15 +205,14 @@ func (s *SkyWalking) AfterCallback() func(db *gorm.DB) {
 
 		defer span.End()
 
+		if ctx, ok := db.InstanceGet(ctxKey); ok {
+			db.Statement.Context = ctx.(context.Context)
+		}
+
 		sql := db.Statement.SQL.String()
 		vars := db.Statement.Vars
 		err := db.Statement.Error
 
-		span.SetComponent(s.opts.componentID)
-		span.SetSpanLayer(agentv3.SpanLayer_Database)
-		span.Tag(go2sky.TagDBType, string(s.opts.dbType))
-		span.Tag(go2sky.TagDBInstance, s.opts.peer)
-
 		if s.opts.reportQuery {
 			span.Tag(go2sky.TagDBStatement, sql)
 		}
@@ -138,11 +221,44 @@ func (s *SkyWalking) AfterCallback() func(db *gorm.DB) {
 		}
 
 		if err != nil {
-			span.Error(time.Now(), err.Error())
+			if s.opts.isError(err) {
+				span.Error(time.Now(), err.Error())
+			} else {
+				span.Log(time.Now(), "error", err.Error())
+			}
+		} else if s.opts.reportResult && db.RowsAffected >= 0 {
+			span.Tag(tagDBRowsAffected, strconv.FormatInt(db.RowsAffected, 10))
 		}
 	}
 }
 
+// tagDB tag the database type, the peer as instance and the database name if it is known
+func (s *SkyWalking) tagDB(span go2sky.Span) {
+	span.Tag(go2sky.TagDBType, string(s.opts.dbType))
+	span.Tag(go2sky.TagDBInstance, s.opts.peer)
+	if s.opts.database != "" {
+		span.Tag(tagDBName, s.opts.database)
+	}
+}
+
+// modelName return the name of the model, or the table if the model is unknown
+func modelName(stmt *gorm.Statement) string {
+	if stmt.Schema != nil && stmt.Schema.Name != "" {
+		return stmt.Schema.Name
+	}
+	return stmt.Table
+}
+
+// preloadNames return the sorted names of the preload associations
+func preloadNames(preloads map[string][]interface{}) string {
+	names := make([]string, 0, len(preloads))
+	for name := range preloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func argsToString(args []interface{}) string {
 	sb := strings.Builder{}
 
@@ -0,0 +1,280 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkyAPM/go2sky"
+	sqlplugin "github.com/SkyAPM/go2sky-plugins/sql"
+	"gorm.io/gorm"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+func TestSpanAttributes(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, fakeDsn)
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		db := db.WithContext(ctx)
+		db.Create(&User{})
+		db.First(&User{})
+		db.Exec("fail")
+	})
+	want := []string{"users/create", "users/query", "/raw"}
+	if names := spanNames(spans); len(names) != len(want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for i, s := range spans {
+		if s.OperationName() != want[i] {
+			t.Errorf("span %d = %s, want %s", i, s.OperationName(), want[i])
+		}
+		if s.SpanType() != agentv3.SpanType_Exit {
+			t.Errorf("%s span type = %v, want Exit", s.OperationName(), s.SpanType())
+		}
+		if s.SpanLayer() != agentv3.SpanLayer_Database {
+			t.Errorf("%s span layer = %v, want Database", s.OperationName(), s.SpanLayer())
+		}
+		if s.ComponentID() != sqlplugin.ComponentID(MYSQL) {
+			t.Errorf("%s component = %d, want %d", s.OperationName(), s.ComponentID(), sqlplugin.ComponentID(MYSQL))
+		}
+		if s.Peer() != "mysql:3306" {
+			t.Errorf("%s peer = %s, want mysql:3306", s.OperationName(), s.Peer())
+		}
+		tags := spanTags(s)
+		if tags[string(go2sky.TagDBType)] != "mysql" {
+			t.Errorf("%s db.type = %s, want mysql", s.OperationName(), tags[string(go2sky.TagDBType)])
+		}
+		if tags[string(go2sky.TagDBInstance)] != "mysql:3306" {
+			t.Errorf("%s db.instance = %s, want mysql:3306", s.OperationName(), tags[string(go2sky.TagDBInstance)])
+		}
+		if tags[string(tagDBName)] != "db" {
+			t.Errorf("%s db.name = %s, want db", s.OperationName(), tags[string(tagDBName)])
+		}
+	}
+	if !spans[2].IsError() {
+		t.Error("failed raw sql should be error")
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		spans []string
+	}{
+		{name: "default", spans: []string{"users/query", "/raw"}},
+		{name: "model", opts: []Option{WithModelOperationName()}, spans: []string{"gorm/User/query", "gorm/raw"}},
+		{name: "namer", opts: []Option{WithModelOperationName(), WithOperationNamer(func(stmt *gorm.Statement, operation string) string {
+			return "custom/" + stmt.Table + "/" + operation
+		})}, spans: []string{"custom/users/query", "custom//raw"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, fakeDsn, tt.opts...)
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				db := db.WithContext(ctx)
+				db.Find(&[]User{})
+				db.Exec("SELECT 1")
+			})
+			names := spanNames(spans)
+			if len(names) != len(tt.spans) {
+				t.Fatalf("spans = %v, want %v", names, tt.spans)
+			}
+			for i := range tt.spans {
+				if names[i] != tt.spans[i] {
+					t.Errorf("span %d = %s, want %s", i, names[i], tt.spans[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResultAndModelReport(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, fakeDsn, WithResultReport(), WithModelReport())
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		db := db.WithContext(ctx)
+		db.Create(&User{})
+		db.Preload("Orders.Items").Preload("Orders").Find(&[]User{})
+		db.Exec("fail")
+	})
+	if len(spans) != 5 {
+		t.Fatalf("want 5 spans, got %v", spanNames(spans))
+	}
+	tags := spanTags(spans[0])
+	if tags[string(tagDBRowsAffected)] != "1" || tags[string(tagGormModel)] != "User" {
+		t.Errorf("create tags = %v", tags)
+	}
+	tags = spanTags(spans[1])
+	if tags[string(tagGormModel)] != "User" || tags[string(tagGormPreload)] != "Orders, Orders.Items" {
+		t.Errorf("query tags = %v", tags)
+	}
+	if tags := spanTags(findSpan(spans, "items/query")); tags[string(tagGormModel)] != "Item" {
+		t.Errorf("preload tags = %v", tags)
+	}
+	tags = spanTags(spans[4])
+	if _, ok := tags[string(tagDBRowsAffected)]; ok {
+		t.Errorf("rows affected of failed statement is reported: %v", tags)
+	}
+	if _, ok := tags[string(tagGormModel)]; ok {
+		t.Errorf("model of raw sql is reported: %v", tags)
+	}
+}
+
+func TestPreloadSpan(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		spans   []string
+		parents []int
+	}{
+		{
+			name:    "default",
+			spans:   []string{"users/query", "orders/query", "items/query", "users/query"},
+			parents: []int{0, 0, 0, 0},
+		},
+		{
+			// the nested preload is the child of its parent preload
+			name:    "preload span",
+			opts:    []Option{WithPreloadSpan()},
+			spans:   []string{"users/query", "orders/preload", "items/preload", "users/query"},
+			parents: []int{0, 1, 2, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, fakeDsn, tt.opts...)
+
+			var root int32
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				root = go2sky.SpanID(ctx)
+				db := db.WithContext(ctx)
+				db.Preload("Orders.Items").Find(&[]User{})
+				// the context of the statement is restored after the preloads
+				db.Find(&[]User{})
+			})
+			names := spanNames(spans)
+			if len(names) != len(tt.spans) {
+				t.Fatalf("spans = %v, want %v", names, tt.spans)
+			}
+			for i := range tt.spans {
+				if names[i] != tt.spans[i] {
+					t.Errorf("span %d = %s, want %s", i, names[i], tt.spans[i])
+				}
+				parent := root
+				if tt.parents[i] > 0 {
+					parent = spans[tt.parents[i]-1].Context().SpanID
+				}
+				if spans[i].Context().ParentSpanID != parent {
+					t.Errorf("parent of %s = %d, want %d", names[i], spans[i].Context().ParentSpanID, parent)
+				}
+				if spans[i].SpanType() != agentv3.SpanType_Exit {
+					t.Errorf("%s span type = %v, want Exit", names[i], spans[i].SpanType())
+				}
+			}
+			// the query span ends after the preload queries
+			if tt.parents[1] > 0 && spans[0].EndTime() < spans[2].EndTime() {
+				t.Error("query span ends before the preload queries")
+			}
+		})
+	}
+}
+
+func TestErrorClassifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		notFound bool
+		failed   bool
+	}{
+		{name: "default", failed: true},
+		{name: "classifier", opts: []Option{WithErrorClassifier(func(err error) bool {
+			return err != errFake
+		})}, notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, fakeDsn, tt.opts...)
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				db := db.WithContext(ctx)
+				if err := db.Where("name = 'none'").First(&User{}).Error; err != gorm.ErrRecordNotFound {
+					t.Errorf("first error = %v, want %v", err, gorm.ErrRecordNotFound)
+				}
+				db.Exec("fail")
+			})
+			if len(spans) != 2 {
+				t.Fatalf("want 2 spans, got %v", spanNames(spans))
+			}
+			for i, isError := range []bool{tt.notFound, tt.failed} {
+				if spans[i].IsError() != isError {
+					t.Errorf("%s is error = %v, want %v", spans[i].OperationName(), spans[i].IsError(), isError)
+				}
+				// the errors which do not mark the span are logged
+				if len(spans[i].Logs()) != 1 {
+					t.Errorf("%s logs = %v, want the error", spans[i].OperationName(), spans[i].Logs())
+				}
+			}
+		})
+	}
+}
+
+func TestPreloadSpanContextInTransaction(t *testing.T) {
+	type ctxKey struct{}
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, fakeDsn, WithPreloadSpan(), WithTransactionSpan())
+	var values []interface{}
+	if err := db.Callback().Query().Before("gorm:query").Register("test:context", func(db *gorm.DB) {
+		values = append(values, db.Statement.Context.Value(ctxKey{}))
+	}); err != nil {
+		t.Fatalf("register callback error: %v", err)
+	}
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		tx := db.WithContext(ctx).Begin()
+		reqCtx := context.WithValue(ctx, ctxKey{}, "request")
+		tx.WithContext(reqCtx).Preload("Orders").Find(&[]User{})
+		tx.Commit()
+	})
+	// the preload query runs with the context of the statement instead of the transaction
+	if len(values) != 2 || values[0] != "request" || values[1] != "request" {
+		t.Errorf("context values of the queries = %v, want request", values)
+	}
+	want := []string{"transaction", "transaction/begin", "users/query", "orders/preload", "transaction/commit"}
+	names := spanNames(spans)
+	if len(names) != len(want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("span %d = %s, want %s", i, names[i], want[i])
+		}
+	}
+	if spans[2].Context().ParentSpanID != spans[0].Context().SpanID {
+		t.Error("query is not the child of transaction span")
+	}
+	if spans[3].Context().ParentSpanID != spans[2].Context().SpanID {
+		t.Error("preload is not the child of query span")
+	}
+}
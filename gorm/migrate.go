@@ -0,0 +1,60 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import "strings"
+
+// schema tables read by the migrators of gorm to inspect the current schema
+var inspectedSchemas = []string{
+	"information_schema",
+	"sqlite_master",
+	"pg_catalog",
+	"pg_indexes",
+	"sys.indexes",
+	"sys.tables",
+}
+
+// migrationOperation return the operation of the schema migration statement, like
+// create_table, alter_table or inspect, or empty if sql is not a migration statement
+func migrationOperation(sql string) string {
+	words := sqlKeywords(sql, 5)
+	if len(words) == 0 {
+		return ""
+	}
+
+	switch verb := words[0]; verb {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE", "COMMENT":
+		for _, object := range words[1:] {
+			switch object {
+			case "OR", "REPLACE", "UNIQUE", "TEMPORARY", "TEMP", "FULLTEXT", "SPATIAL", "ON":
+				continue
+			}
+			return strings.ToLower(verb + "_" + object)
+		}
+		return strings.ToLower(verb)
+	case "SHOW", "DESCRIBE", "DESC", "PRAGMA":
+		return "inspect"
+	case "SELECT":
+		lower := strings.ToLower(sql)
+		for _, schema := range inspectedSchemas {
+			if strings.Contains(lower, schema) {
+				return "inspect"
+			}
+		}
+	}
+	return ""
+}
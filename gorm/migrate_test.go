@@ -0,0 +1,84 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMigrationOperation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE TABLE `users` (`id` bigint)", "create_table"},
+		{"create table if not exists users (id int)", "create_table"},
+		{"CREATE UNIQUE INDEX idx_name ON users(name)", "create_index"},
+		{"CREATE OR REPLACE VIEW v AS SELECT 1", "create_view"},
+		{"ALTER TABLE users ADD name text", "alter_table"},
+		{"DROP TABLE IF EXISTS users", "drop_table"},
+		{"RENAME TABLE users TO people", "rename_table"},
+		{"TRUNCATE TABLE users", "truncate_table"},
+		{"CREATE", "create"},
+		{"SHOW TABLES", "inspect"},
+		{"PRAGMA table_info(users)", "inspect"},
+		{"SELECT count(*) FROM information_schema.tables WHERE table_name = ?", "inspect"},
+		{"SELECT * FROM sqlite_master", "inspect"},
+		{"SELECT * FROM users", ""},
+		{"INSERT INTO users (id) VALUES (1)", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := migrationOperation(tt.sql); got != tt.want {
+			t.Errorf("migrationOperation(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		spans []string
+	}{
+		{name: "default", spans: []string{"/raw", "/row", "/row", "/row"}},
+		// HasTable of mysql selects the current database before inspecting information_schema
+		{name: "migration", opts: []Option{WithMigrationTrace()}, spans: []string{"migrate/create_table", "/row", "migrate/inspect", "migrate/inspect"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, fakeDsn, tt.opts...)
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				db := db.WithContext(ctx)
+				db.Exec("CREATE TABLE users (id int)")
+				db.Migrator().HasTable(&User{})
+			})
+			names := spanNames(spans)
+			if len(names) != len(tt.spans) {
+				t.Fatalf("spans = %v, want %v", names, tt.spans)
+			}
+			for i := range tt.spans {
+				if names[i] != tt.spans[i] {
+					t.Errorf("span %d = %s, want %s", i, names[i], tt.spans[i])
+				}
+			}
+		})
+	}
+}
@@ -16,27 +16,73 @@
 
 package gorm
 
-type DBType string
+import (
+	"errors"
+	"fmt"
 
-const (
-	UNKNOWN DBType = "unknown"
-	MYSQL   DBType = "mysql"
+	sqlplugin "github.com/SkyAPM/go2sky-plugins/sql"
+	"gorm.io/gorm"
 )
 
+// DBType database type, the same as the DBType of the sql plugin
+type DBType = sqlplugin.DBType
+
 const (
-	componentIDUnknown = 0
-	componentIDMysql   = 5012
+	// UNKNOWN unknown database
+	UNKNOWN = sqlplugin.UNKNOWN
+	// MYSQL mysql
+	MYSQL = sqlplugin.MYSQL
+	// POSTGRESQL postgresql, dsn of lib/pq and pgx
+	POSTGRESQL = sqlplugin.POSTGRESQL
+	// MSSQL sql server, dsn of go-mssqldb
+	MSSQL = sqlplugin.MSSQL
+	// ORACLE oracle, dsn of godror and go-ora
+	ORACLE = sqlplugin.ORACLE
+	// SQLITE sqlite, the database file is reported as peer
+	SQLITE = sqlplugin.SQLITE
+	// CLICKHOUSE clickhouse, dsn of clickhouse-go
+	CLICKHOUSE = sqlplugin.CLICKHOUSE
+	// IPV4 others database type
+	IPV4 = sqlplugin.IPV4
 )
 
+const defaultPeer = "unknown"
+
 type Option func(*options)
 
+// ErrorClassifier report whether the error of the statement marks the span as error,
+// the errors which are not are logged in the span
+type ErrorClassifier func(err error) bool
+
+// DefaultErrorClassifier marks the span as error unless the error is gorm.ErrRecordNotFound
+func DefaultErrorClassifier(err error) bool {
+	return !errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// OperationNamer name the span of the operation, like create, query, preload or raw,
+// by the statement
+type OperationNamer func(stmt *gorm.Statement, operation string) string
+
 type options struct {
 	dbType      DBType
 	peer        string
+	database    string
 	componentID int32
 
-	reportQuery bool
-	reportParam bool
+	reportQuery  bool
+	reportParam  bool
+	reportResult bool
+	reportModel  bool
+
+	modelOpName  bool
+	dbTypeOpName bool
+	opNamer      OperationNamer
+	tracePreload bool
+
+	errorClassifier ErrorClassifier
+
+	traceTx        bool
+	traceMigration bool
 }
 
 // WithSqlDBType set dbType option,
@@ -69,11 +115,126 @@ func WithParamReport() Option {
 	}
 }
 
-func (o *options) setComponentID() {
-	switch o.dbType {
-	case MYSQL:
-		o.componentID = componentIDMysql
-	default:
-		o.componentID = componentIDUnknown
+// WithResultReport if set, the rows affected of the statement would be collected
+func WithResultReport() Option {
+	return func(o *options) {
+		o.reportResult = true
+	}
+}
+
+// WithModelReport if set, the model name and the preload associations
+// of the statement would be collected
+func WithModelReport() Option {
+	return func(o *options) {
+		o.reportModel = true
+	}
+}
+
+// WithModelOperationName if set, the span is named gorm/<Model>/<operation>, like gorm/User/query,
+// instead of <table>/<operation>, the table is used if the model is unknown
+func WithModelOperationName() Option {
+	return func(o *options) {
+		o.modelOpName = true
+	}
+}
+
+// WithDBTypeOperationName if set, the span is prefixed by the dbType like the sql plugin,
+// e.g. Mysql/Go2Sky/users/query, the spans named by WithOperationNamer are not prefixed
+func WithDBTypeOperationName() Option {
+	return func(o *options) {
+		o.dbTypeOpName = true
+	}
+}
+
+// WithOperationNamer set the func to name the spans by yourself
+func WithOperationNamer(namer OperationNamer) Option {
+	return func(o *options) {
+		o.opNamer = namer
+	}
+}
+
+// WithPreloadSpan if set, the query span with Preload ends after the preload queries,
+// and every preload query is traced as its child span named by the preload operation
+func WithPreloadSpan() Option {
+	return func(o *options) {
+		o.tracePreload = true
 	}
 }
+
+// WithErrorClassifier set the func to report whether the error of the statement
+// marks the span as error, DefaultErrorClassifier is used by default
+func WithErrorClassifier(classifier ErrorClassifier) Option {
+	return func(o *options) {
+		o.errorClassifier = classifier
+	}
+}
+
+// WithTransactionSpan if set, the ConnPool of gorm.DB is wrapped in Initialize to open a local
+// transaction span at Begin, it parents the statements and savepoints in the transaction and
+// closes on commit or rollback with the outcome tagged as db.transaction.outcome, a rollback
+// marks the transaction span as error
+func WithTransactionSpan() Option {
+	return func(o *options) {
+		o.traceTx = true
+	}
+}
+
+// WithMigrationTrace if set, the schema statements of raw sql and the migrator, like
+// CREATE TABLE and the inspection of information_schema, are named migrate/<operation>,
+// like migrate/create_table, instead of <table>/raw
+func WithMigrationTrace() Option {
+	return func(o *options) {
+		o.traceMigration = true
+	}
+}
+
+func (o *options) getOpName(stmt *gorm.Statement, operation string) string {
+	if o.opNamer != nil {
+		return o.opNamer(stmt, operation)
+	}
+	if o.modelOpName {
+		if model := modelName(stmt); model != "" {
+			return o.prefix(fmt.Sprintf("gorm/%s/%s", model, operation))
+		}
+		return o.prefix("gorm/" + operation)
+	}
+	return o.prefix(fmt.Sprintf("%s/%s", stmt.Table, operation))
+}
+
+// prefix the operation name by the dbType if WithDBTypeOperationName is set
+func (o *options) prefix(name string) string {
+	if !o.dbTypeOpName {
+		return name
+	}
+	return sqlplugin.OperationPrefix(o.dbType) + name
+}
+
+// isError report whether err marks the span as error
+func (o *options) isError(err error) bool {
+	if o.errorClassifier != nil {
+		return o.errorClassifier(err)
+	}
+	return DefaultErrorClassifier(err)
+}
+
+// detect the dbType, peer and database name from the dialector if they are not set by the options
+func (o *options) detect(dialector gorm.Dialector) {
+	if dialector == nil {
+		return
+	}
+	if o.dbType == UNKNOWN {
+		o.dbType = dialectorDBType(dialector.Name())
+		o.setComponentID()
+	}
+	dsn := dialectorDSN(dialector)
+	if o.peer == defaultPeer {
+		if peer := sqlplugin.ParseDsn(o.dbType, dsn); peer != "" {
+			o.peer = peer
+		}
+	}
+	o.database = sqlplugin.ParseDatabase(o.dbType, dsn)
+}
+
+func (o *options) setComponentID() {
+	o.componentID = sqlplugin.ComponentID(o.dbType)
+}
@@ -0,0 +1,305 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	"gorm.io/gorm"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+const tagDBTxOutcome go2sky.Tag = "db.transaction.outcome"
+
+var (
+	_ gorm.ConnPoolBeginner = &connPool{}
+	_ gorm.GetDBConnector   = &connPool{}
+	_ gorm.TxBeginner       = &sqlConnPool{}
+	_ gorm.GetDBConnector   = &sqlConnPool{}
+	_ gorm.TxCommitter      = &txConnPool{}
+)
+
+// connPool wrap the ConnPool of gorm.Statement to trace the transactions began by it
+type connPool struct {
+	gorm.ConnPool
+
+	s *SkyWalking
+}
+
+// sqlConnPool wrap the ConnPool of gorm.Config which begins the transactions by gorm.TxBeginner,
+// the sessions of PrepareStmt build their ConnPool on it and need the *sql.Tx, so only the
+// begin of their transactions is traced
+type sqlConnPool struct {
+	gorm.ConnPool
+
+	beginner gorm.TxBeginner
+	s        *SkyWalking
+}
+
+// wrapConnPool replace the ConnPools of db by the traced ones, the ConnPool is kept if it
+// can not begin a transaction
+func (s *SkyWalking) wrapConnPool(db *gorm.DB) {
+	if db.Statement != nil {
+		switch db.Statement.ConnPool.(type) {
+		case *connPool, *sqlConnPool:
+		case gorm.TxBeginner, gorm.ConnPoolBeginner:
+			db.Statement.ConnPool = &connPool{ConnPool: db.Statement.ConnPool, s: s}
+		}
+	}
+
+	if _, ok := db.Config.ConnPool.(*sqlConnPool); ok {
+		return
+	}
+	if beginner, ok := db.Config.ConnPool.(gorm.TxBeginner); ok {
+		db.Config.ConnPool = &sqlConnPool{ConnPool: db.Config.ConnPool, beginner: beginner, s: s}
+	}
+}
+
+// GetDBConn return the underlying sql.DB, so that gorm.DB.DB() keeps working
+func (p *sqlConnPool) GetDBConn() (*sql.DB, error) {
+	return getDBConn(p.ConnPool)
+}
+
+// BeginTx support trace, the begin is traced without the transaction span
+// because the end of *sql.Tx can not be traced
+func (p *sqlConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	span := p.s.createTxExitSpan(ctx, "begin")
+	tx, err := p.beginner.BeginTx(ctx, opts)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	span.End()
+	return tx, err
+}
+
+// GetDBConn return the underlying sql.DB, so that gorm.DB.DB() keeps working
+func (p *connPool) GetDBConn() (*sql.DB, error) {
+	return getDBConn(p.ConnPool)
+}
+
+func getDBConn(pool gorm.ConnPool) (*sql.DB, error) {
+	if connector, ok := pool.(gorm.GetDBConnector); ok && connector != nil {
+		return connector.GetDBConn()
+	}
+	if db, ok := pool.(*sql.DB); ok {
+		return db, nil
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// BeginTx support trace
+func (p *connPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx := &txConnPool{
+		s:   p.s,
+		ctx: ctx,
+	}
+	if span, txCtx := p.s.createTxSpan(ctx); span != nil {
+		tx.span = span
+		tx.ctx = txCtx
+		tx.parentSpanID = go2sky.SpanID(ctx)
+		tx.parentSegmentID = go2sky.TraceSegmentID(ctx)
+	}
+
+	span := p.s.createTxExitSpan(tx.ctx, "begin")
+	var err error
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		var sqlTx *sql.Tx
+		if sqlTx, err = beginner.BeginTx(ctx, opts); err == nil {
+			tx.ConnPool = sqlTx
+		}
+	case gorm.ConnPoolBeginner:
+		tx.ConnPool, err = beginner.BeginTx(ctx, opts)
+	default:
+		err = gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	span.End()
+	if err != nil {
+		tx.end("begin", err)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// txConnPool wrap the ConnPool of a transaction, the statements of the transaction
+// are the children of the transaction span
+type txConnPool struct {
+	gorm.ConnPool
+
+	s   *SkyWalking
+	ctx context.Context
+
+	span            go2sky.Span
+	parentSpanID    int32
+	parentSegmentID string
+	once            sync.Once
+}
+
+// Commit support trace
+func (tx *txConnPool) Commit() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	span := tx.s.createTxExitSpan(tx.ctx, "commit")
+
+	err := committer.Commit()
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	span.End()
+	tx.end("commit", err)
+	return err
+}
+
+// Rollback support trace, the transaction span is marked as error
+func (tx *txConnPool) Rollback() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	span := tx.s.createTxExitSpan(tx.ctx, "rollback")
+
+	err := committer.Rollback()
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	span.End()
+	tx.end("rollback", err)
+	return err
+}
+
+// end the transaction span with the outcome, only the first outcome is reported
+func (tx *txConnPool) end(outcome string, err error) {
+	if tx.span == nil {
+		return
+	}
+	tx.once.Do(func() {
+		tx.span.Tag(tagDBTxOutcome, outcome)
+		if err != nil {
+			tx.span.Error(time.Now(), err.Error())
+		} else if outcome == "rollback" {
+			tx.span.Error(time.Now(), "transaction rollback")
+		}
+		tx.span.End()
+	})
+}
+
+// spanContext return the context to create the span of statement
+func (tx *txConnPool) spanContext(ctx context.Context) context.Context {
+	id := go2sky.SpanID(ctx)
+	if id == go2sky.EmptySpanID {
+		// if ctx do not contain parent span, use transaction ctx instead
+		return tx.ctx
+	}
+	if tx.span != nil && id == tx.parentSpanID && go2sky.TraceSegmentID(ctx) == tx.parentSegmentID {
+		// ctx began the transaction, use the transaction span as parent instead
+		return tx.ctx
+	}
+	return ctx
+}
+
+// createTxSpan create the local transaction span, nil is returned if the span can not be created
+func (s *SkyWalking) createTxSpan(ctx context.Context) (go2sky.Span, context.Context) {
+	if s.tracer == nil || ctx == nil {
+		return nil, nil
+	}
+	span, nCtx, err := s.tracer.CreateLocalSpan(ctx, go2sky.WithOperationName(s.opts.prefix("transaction")))
+	if err != nil {
+		return nil, nil
+	}
+	span.SetComponent(s.opts.componentID)
+	span.SetSpanLayer(agentv3.SpanLayer_Database)
+	s.tagDB(span)
+	return span, nCtx
+}
+
+// createTxExitSpan create the exit span of begin, commit or rollback, a noop span is
+// returned if the span can not be created
+func (s *SkyWalking) createTxExitSpan(ctx context.Context, operation string) go2sky.Span {
+	if s.tracer == nil || ctx == nil {
+		return &go2sky.NoopSpan{}
+	}
+	span, err := s.tracer.CreateExitSpan(ctx, s.opts.prefix("transaction/"+operation), s.opts.peer, func(key, value string) error {
+		return nil
+	})
+	if err != nil {
+		return &go2sky.NoopSpan{}
+	}
+	span.SetComponent(s.opts.componentID)
+	span.SetSpanLayer(agentv3.SpanLayer_Database)
+	s.tagDB(span)
+	return span
+}
+
+// savepointOperation return the operation of the savepoint statement, or empty
+// if sql is not a savepoint statement
+func savepointOperation(sql string) string {
+	words := sqlKeywords(sql, 3)
+	if len(words) == 0 {
+		return ""
+	}
+	switch words[0] {
+	case "SAVEPOINT":
+		return "savepoint"
+	case "SAVE":
+		// SAVE TRANSACTION of sql server
+		if len(words) > 1 && (words[1] == "TRANSACTION" || words[1] == "TRAN") {
+			return "savepoint"
+		}
+	case "RELEASE":
+		return "release_savepoint"
+	case "ROLLBACK":
+		if len(words) > 1 && words[1] == "TO" {
+			return "rollback_to_savepoint"
+		}
+		// ROLLBACK TRANSACTION name of sql server
+		if len(words) > 2 && (words[1] == "TRANSACTION" || words[1] == "TRAN") {
+			return "rollback_to_savepoint"
+		}
+	}
+	return ""
+}
+
+// sqlKeywords return at most n leading words of sql in upper case
+func sqlKeywords(sql string, n int) []string {
+	words := make([]string, 0, n)
+	for len(words) < n {
+		sql = strings.TrimLeft(sql, " \t\r\n(")
+		if sql == "" {
+			break
+		}
+		end := strings.IndexAny(sql, " \t\r\n(;")
+		if end < 0 {
+			end = len(sql)
+		}
+		if end == 0 {
+			break
+		}
+		words = append(words, strings.ToUpper(sql[:end]))
+		sql = sql[end:]
+	}
+	return words
+}
@@ -0,0 +1,214 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gorm
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+func TestTransactionSpan(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      func(db *gorm.DB)
+		spans   []string
+		outcome string
+	}{
+		{
+			name: "commit",
+			fn: func(db *gorm.DB) {
+				if err := db.Transaction(func(tx *gorm.DB) error {
+					return tx.Create(&User{}).Error
+				}); err != nil {
+					t.Errorf("transaction error: %v", err)
+				}
+			},
+			spans:   []string{"transaction", "transaction/begin", "users/create", "transaction/commit"},
+			outcome: "commit",
+		},
+		{
+			name: "rollback",
+			fn: func(db *gorm.DB) {
+				if err := db.Transaction(func(tx *gorm.DB) error {
+					tx.Create(&User{})
+					return errFake
+				}); err != errFake {
+					t.Errorf("transaction error = %v, want %v", err, errFake)
+				}
+			},
+			spans:   []string{"transaction", "transaction/begin", "users/create", "transaction/rollback"},
+			outcome: "rollback",
+		},
+		{
+			name: "begin",
+			fn: func(db *gorm.DB) {
+				tx := db.Begin()
+				tx.Where("id = 1").Delete(&User{})
+				if err := tx.Commit().Error; err != nil {
+					t.Errorf("commit error: %v", err)
+				}
+			},
+			spans:   []string{"transaction", "transaction/begin", "users/delete", "transaction/commit"},
+			outcome: "commit",
+		},
+		{
+			name: "default transaction",
+			fn: func(db *gorm.DB) {
+				db.Create(&User{})
+			},
+			spans:   []string{"transaction", "transaction/begin", "users/create", "transaction/commit"},
+			outcome: "commit",
+		},
+		{
+			name: "savepoint",
+			fn: func(db *gorm.DB) {
+				db.Transaction(func(tx *gorm.DB) error {
+					tx.Transaction(func(tx *gorm.DB) error {
+						return tx.Create(&User{}).Error
+					})
+					tx.Transaction(func(tx *gorm.DB) error {
+						return errFake
+					})
+					return nil
+				})
+			},
+			spans: []string{"transaction", "transaction/begin",
+				"transaction/savepoint", "users/create",
+				"transaction/savepoint", "transaction/rollback_to_savepoint",
+				"transaction/commit"},
+			outcome: "commit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, fakeDsn, WithTransactionSpan())
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				tt.fn(db.WithContext(ctx))
+			})
+			names := spanNames(spans)
+			if len(names) != len(tt.spans) {
+				t.Fatalf("spans = %v, want %v", names, tt.spans)
+			}
+			for i := range tt.spans {
+				if names[i] != tt.spans[i] {
+					t.Errorf("span %d = %s, want %s", i, names[i], tt.spans[i])
+				}
+			}
+
+			txSpan := spans[0]
+			if txSpan.SpanType() != agentv3.SpanType_Local {
+				t.Errorf("transaction span type = %v, want Local", txSpan.SpanType())
+			}
+			if outcome := spanTags(txSpan)[string(tagDBTxOutcome)]; outcome != tt.outcome {
+				t.Errorf("outcome = %s, want %s", outcome, tt.outcome)
+			}
+			if txSpan.IsError() != (tt.outcome == "rollback") {
+				t.Errorf("transaction span is error = %v, want %v", txSpan.IsError(), tt.outcome == "rollback")
+			}
+			for _, s := range spans[1:] {
+				if s.Context().ParentSpanID != txSpan.Context().SpanID {
+					t.Errorf("%s is not the child of transaction span", s.OperationName())
+				}
+				if s.SpanType() != agentv3.SpanType_Exit {
+					t.Errorf("%s span type = %v, want Exit", s.OperationName(), s.SpanType())
+				}
+				if s.IsError() != (s.OperationName() == "transaction/rollback_to_savepoint") {
+					t.Errorf("%s is error = %v", s.OperationName(), s.IsError())
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionSpanPrepareStmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *gorm.Config
+		session *gorm.Session
+		spans   []string
+	}{
+		{
+			name:   "config",
+			config: &gorm.Config{PrepareStmt: true},
+			spans:  []string{"transaction", "transaction/begin", "users/create", "transaction/commit"},
+		},
+		{
+			name:    "session",
+			config:  &gorm.Config{},
+			session: &gorm.Session{PrepareStmt: true},
+			spans:   []string{"transaction/begin", "users/create"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDBWithConfig(t, tracer, fakeDsn, tt.config, WithTransactionSpan())
+			if tt.session != nil {
+				db = db.Session(tt.session)
+			}
+			if _, err := db.DB(); err != nil {
+				t.Errorf("get sql db error: %v", err)
+			}
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					return tx.Create(&User{}).Error
+				}); err != nil {
+					t.Errorf("transaction error: %v", err)
+				}
+			})
+			names := spanNames(spans)
+			if len(names) != len(tt.spans) {
+				t.Fatalf("spans = %v, want %v", names, tt.spans)
+			}
+			for i := range tt.spans {
+				if names[i] != tt.spans[i] {
+					t.Errorf("span %d = %s, want %s", i, names[i], tt.spans[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSavepointOperation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SAVEPOINT sp1", "savepoint"},
+		{"savepoint sp1", "savepoint"},
+		{"SAVE TRANSACTION sp1", "savepoint"},
+		{"RELEASE SAVEPOINT sp1", "release_savepoint"},
+		{"ROLLBACK TO SAVEPOINT sp1", "rollback_to_savepoint"},
+		{"rollback to sp1", "rollback_to_savepoint"},
+		{"ROLLBACK TRANSACTION sp1", "rollback_to_savepoint"},
+		{"ROLLBACK", ""},
+		{"SAVE users", ""},
+		{"SELECT * FROM savepoints", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := savepointOperation(tt.sql); got != tt.want {
+			t.Errorf("savepointOperation(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafkareporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	commonv3 "skywalking.apache.org/repo/goapi/collect/common/v3"
+)
+
+const cdsCommand = "ConfigurationDiscoveryCommand"
+
+// ConfigFetcher fetch the dynamic configurations of the service,
+// uuid is the identity of the current configurations and is empty before the first change.
+type ConfigFetcher interface {
+	FetchConfigurations(ctx context.Context, service string, uuid string) (*commonv3.Commands, error)
+}
+
+// ConfigFetcherFunc is an adapter to allow the use of ordinary functions as ConfigFetcher
+type ConfigFetcherFunc func(ctx context.Context, service string, uuid string) (*commonv3.Commands, error)
+
+// FetchConfigurations calls f(ctx, service, uuid)
+func (f ConfigFetcherFunc) FetchConfigurations(ctx context.Context, service string, uuid string) (*commonv3.Commands, error) {
+	return f(ctx, service, uuid)
+}
+
+func (r *kafkaReporter) initCDS(cdsWatchers []go2sky.AgentConfigChangeWatcher) {
+	if r.cdsFetcher == nil || r.cdsInterval <= 0 {
+		return
+	}
+
+	// bind watchers
+	r.cdsService = go2sky.NewConfigDiscoveryService()
+	r.cdsService.BindWatchers(cdsWatchers)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cdsInterval)
+		defer ticker.Stop()
+		for {
+			r.fetchConfigurations()
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *kafkaReporter) fetchConfigurations() {
+	commands, err := r.cdsFetcher.FetchConfigurations(r.ctx, r.service, r.cdsService.UUID)
+	if err != nil {
+		r.logger.Printf("fetch dynamic configuration error %v", err)
+		return
+	}
+
+	for _, command := range commands.GetCommands() {
+		if command.Command == cdsCommand {
+			// watchers are notified only if the uuid is changed
+			r.cdsService.HandleCommand(command)
+			return
+		}
+	}
+}
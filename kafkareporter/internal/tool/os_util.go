@@ -17,12 +17,23 @@
 package tool
 
 import (
+	"io/ioutil"
 	"net"
 	"os"
+	"regexp"
 	"runtime"
 	"strconv"
 )
 
+var (
+	// the id is the last element of the cgroup path, eg: /docker/<id>, /kubepods/.../<id>
+	// or /system.slice/docker-<id>.scope
+	cgroupContainerIDPattern = regexp.MustCompile(`(?m)[/-]([0-9a-f]{64})(?:\.scope)?$`)
+	// the id of the mounted hostname or resolv.conf on cgroup v2, eg: /var/lib/docker/containers/<id>/hostname,
+	// the other 64 hex in mountinfo like the overlay2 layer ids are not the container id
+	mountContainerIDPattern = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+)
+
 // ProcessNo pid
 func ProcessNo() string {
 	if os.Getpid() > 0 {
@@ -73,3 +84,24 @@ func IPV4() string {
 	}
 	return "no-hostname"
 }
+
+// ContainerID container id from cgroup, empty if not running in a container
+func ContainerID() string {
+	sources := []struct {
+		path    string
+		pattern *regexp.Regexp
+	}{
+		{"/proc/self/cgroup", cgroupContainerIDPattern},
+		{"/proc/self/mountinfo", mountContainerIDPattern},
+	}
+	for _, source := range sources {
+		b, err := ioutil.ReadFile(source.path)
+		if err != nil {
+			continue
+		}
+		if match := source.pattern.FindSubmatch(b); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tool
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// GoVersion go version
+func GoVersion() string {
+	return runtime.Version()
+}
+
+// MaxProcs GOMAXPROCS
+func MaxProcs() string {
+	return strconv.Itoa(runtime.GOMAXPROCS(0))
+}
+
+// MainModule main module path and version
+func MainModule() (path, version string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	return info.Main.Path, info.Main.Version
+}
@@ -18,9 +18,11 @@ package kafkareporter
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -33,55 +35,113 @@ import (
 )
 
 const (
-	defaultCheckInterval   = 20 * time.Second
-	defaultKafkaLogPrefix  = "go2sky-kafka"
-	topicKeyRegister       = "register-"
-	defaultTopicManagement = "skywalking-managements"
-	defaultTopicSegment    = "skywalking-segments"
+	defaultCheckInterval      = 20 * time.Second
+	defaultCDSInterval        = 20 * time.Second
+	defaultPropertiesInterval = 10 * time.Minute
+	defaultKafkaLogPrefix     = "go2sky-kafka"
+	topicKeyRegister          = "register-"
+	defaultTopicManagement    = "skywalking-managements"
+	defaultTopicSegment       = "skywalking-segments"
+	defaultTopicLogging       = "skywalking-logs"
+	defaultTopicMeter         = "skywalking-meters"
+	defaultMaxSendQueueSize   = 30000
+	defaultCloseTimeout       = 10 * time.Second
+	defaultBatchLatency       = 100 * time.Millisecond
 )
 
 type kafkaReporter struct {
-	c               *sarama.Config
-	producer        sarama.AsyncProducer
-	service         string
-	serviceInstance string
-	wg              sync.WaitGroup
-	ctx             context.Context
-	cancel          context.CancelFunc
-	instanceProps   map[string]string
-	logger          *log.Logger
-	topicManagement string
-	topicSegment    string
-	checkInterval   time.Duration
-	// cdsInterval      time.Duration
-	// cdsService       *go2sky.ConfigDiscoveryService
-	// cdsClient        configuration.ConfigurationDiscoveryServiceClient
+	// counter is accessed atomically, keep it at the top for 64-bit alignment
+	counter            segmentCounter
+	c                  *sarama.Config
+	producer           sarama.AsyncProducer
+	service            string
+	serviceInstance    string
+	wg                 sync.WaitGroup
+	ctx                context.Context
+	cancel             context.CancelFunc
+	instanceProps      map[string]string
+	logger             *log.Logger
+	topicManagement    string
+	topicSegment       string
+	topicLogging       string
+	topicMeter         string
+	checkInterval      time.Duration
+	propertiesInterval time.Duration
+	runtimeMetrics     bool
+	metricsSampler     *runtimeSampler
+	cdsInterval        time.Duration
+	cdsService         *go2sky.ConfigDiscoveryService
+	cdsFetcher         ConfigFetcher
+	sendCh             chan *sarama.ProducerMessage
+	logCh              chan *sarama.ProducerMessage
+	sendQueueSize      int
+	dropPolicy         DropPolicy
+	blockTimeout       time.Duration
+	batchSize          int
+	batchLatency       time.Duration
+	namespace          string
+	segmentRouter      SegmentRouter
+	spoolDir           string
+	spoolMaxSize       int64
+	spoolMaxAge        time.Duration
+	spool              *spool
+	errorsDone         chan struct{}
+	closed             int32
+	drainCh            chan struct{}
+	pipelineDone       chan struct{}
 }
 
 // New create a new reporter to send data to kafka.
 func New(addrs []string, opts ...Option) (go2sky.Reporter, error) {
 	r := &kafkaReporter{
-		c:               sarama.NewConfig(),
-		logger:          log.New(os.Stderr, defaultKafkaLogPrefix, log.LstdFlags),
-		checkInterval:   defaultCheckInterval,
-		topicManagement: defaultTopicManagement,
-		topicSegment:    defaultTopicSegment,
+		c:                  sarama.NewConfig(),
+		logger:             log.New(os.Stderr, defaultKafkaLogPrefix, log.LstdFlags),
+		checkInterval:      defaultCheckInterval,
+		cdsInterval:        defaultCDSInterval,
+		propertiesInterval: defaultPropertiesInterval,
+		topicManagement:    defaultTopicManagement,
+		topicSegment:       defaultTopicSegment,
+		topicLogging:       defaultTopicLogging,
+		topicMeter:         defaultTopicMeter,
+		runtimeMetrics:     true,
+		metricsSampler:     &runtimeSampler{},
+		sendQueueSize:      defaultMaxSendQueueSize,
+		dropPolicy:         DropNewest,
 	}
 
 	for _, o := range opts {
 		o(r)
 	}
+	r.applyNamespace()
+	r.applyBatch()
+	r.sendCh = make(chan *sarama.ProducerMessage, r.sendQueueSize)
+	r.logCh = make(chan *sarama.ProducerMessage, r.sendQueueSize)
+
+	if r.spoolDir != "" {
+		// the failed segments are spooled from the errors
+		r.c.Producer.Return.Errors = true
+		s, err := newSpool(r.spoolDir, r.spoolMaxSize, r.spoolMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		r.spool = s
+	}
 
 	p, err := sarama.NewAsyncProducer(addrs, r.c)
 	if err != nil {
+		if r.spool != nil {
+			_ = r.spool.close()
+		}
 		return nil, err
 	}
 	r.producer = p
 
 	if r.c.Producer.Return.Errors {
+		r.errorsDone = make(chan struct{})
 		go func() {
+			defer close(r.errorsDone)
 			for e := range p.Errors() {
-				r.logger.Printf("send kafka err: %v", e.Err)
+				r.handleProducerError(e)
 			}
 		}()
 	}
@@ -89,6 +149,9 @@ func New(addrs []string, opts ...Option) (go2sky.Reporter, error) {
 	return r, nil
 }
 
+// SegmentRouter returns the topic to send the segment, the segment topic is used if it returns empty
+type SegmentRouter func(segment *agentv3.SegmentObject) string
+
 // Option allows for functional options to adjust behaviour
 // of a kafka reporter to be created by New
 type Option func(r *kafkaReporter)
@@ -107,6 +170,15 @@ func WithCheckInterval(interval time.Duration) Option {
 	}
 }
 
+// WithPropertiesReportInterval setup the interval of reporting service instance properties again,
+// they are reported on boot, and reported again periodically in case of the OAP restarted.
+// Zero or negative interval means reporting only once.
+func WithPropertiesReportInterval(interval time.Duration) Option {
+	return func(r *kafkaReporter) {
+		r.propertiesInterval = interval
+	}
+}
+
 // WithInstanceProps setup service instance properties eg: org=SkyAPM
 func WithInstanceProps(props map[string]string) Option {
 	return func(r *kafkaReporter) {
@@ -135,11 +207,130 @@ func WithTopicSegment(topicSegment string) Option {
 	}
 }
 
+// WithTopicLogging setup service logging topic
+func WithTopicLogging(topicLogging string) Option {
+	return func(r *kafkaReporter) {
+		r.topicLogging = topicLogging
+	}
+}
+
+// WithTopicMeter setup service meter topic
+func WithTopicMeter(topicMeter string) Option {
+	return func(r *kafkaReporter) {
+		r.topicMeter = topicMeter
+	}
+}
+
+// WithRuntimeMetrics setup whether to report the go runtime metrics, it's enabled by default
+func WithRuntimeMetrics(enable bool) Option {
+	return func(r *kafkaReporter) {
+		r.runtimeMetrics = enable
+	}
+}
+
+// WithConfigFetcher setup the fetcher of configuration discovery service,
+// the configurations are fetched by it and notified to the watchers on Boot
+func WithConfigFetcher(fetcher ConfigFetcher) Option {
+	return func(r *kafkaReporter) {
+		r.cdsFetcher = fetcher
+	}
+}
+
+// WithCDSInterval setup the interval of fetching dynamic configurations
+func WithCDSInterval(interval time.Duration) Option {
+	return func(r *kafkaReporter) {
+		r.cdsInterval = interval
+	}
+}
+
+// WithSendQueueSize setup the max size of segments, and the max size of logs, buffered in memory before sending to kafka
+func WithSendQueueSize(size int) Option {
+	return func(r *kafkaReporter) {
+		r.sendQueueSize = size
+	}
+}
+
+// WithDropPolicy setup the policy to apply when the send queue is full,
+// blockTimeout is the max time to wait with the Block policy
+func WithDropPolicy(policy DropPolicy, blockTimeout time.Duration) Option {
+	return func(r *kafkaReporter) {
+		r.dropPolicy = policy
+		r.blockTimeout = blockTimeout
+	}
+}
+
+// WithSpool setup the directory to persist the segments failed to send,
+// they are replayed in order after kafka recovers. The spooled segments exceed
+// maxSize bytes are dropped, and the ones older than maxAge are not replayed,
+// zero means no limit.
+func WithSpool(dir string, maxSize int64, maxAge time.Duration) Option {
+	return func(r *kafkaReporter) {
+		r.spoolDir = dir
+		r.spoolMaxSize = maxSize
+		r.spoolMaxAge = maxAge
+	}
+}
+
+// WithBatch setup the batching mode, at most maxCount messages in maxLatency are
+// sent in one kafka produce request to reduce the broker requests. Every segment is still
+// one agentv3.SegmentObject record, as the OAP kafka fetcher decodes, so the batching
+// is done by the flush of the producer, it overrides the flush of the config set by WithConfig.
+// maxLatency must be positive, otherwise defaultBatchLatency is used, as the producer
+// without the flush frequency holds the messages until maxCount messages are collected.
+func WithBatch(maxCount int, maxLatency time.Duration) Option {
+	return func(r *kafkaReporter) {
+		r.batchSize = maxCount
+		r.batchLatency = maxLatency
+	}
+}
+
+// WithNamespace setup the namespace of OAP kafka fetcher,
+// all topics are prefixed by "namespace-"
+func WithNamespace(namespace string) Option {
+	return func(r *kafkaReporter) {
+		r.namespace = namespace
+	}
+}
+
+// WithSegmentRouter setup the router to send segments to different topics, eg: the topics of tenants,
+// the topic returned by router is used as is without the namespace prefix
+func WithSegmentRouter(router SegmentRouter) Option {
+	return func(r *kafkaReporter) {
+		r.segmentRouter = router
+	}
+}
+
+func (r *kafkaReporter) applyBatch() {
+	if r.batchSize <= 1 {
+		return
+	}
+	r.c.Producer.Flush.Messages = r.batchSize
+	r.c.Producer.Flush.MaxMessages = r.batchSize
+	r.c.Producer.Flush.Frequency = r.batchLatency
+	if r.batchLatency <= 0 {
+		r.c.Producer.Flush.Frequency = defaultBatchLatency
+	}
+}
+
+func (r *kafkaReporter) applyNamespace() {
+	if r.namespace == "" {
+		return
+	}
+	prefix := r.namespace + "-"
+	r.topicManagement = prefix + r.topicManagement
+	r.topicSegment = prefix + r.topicSegment
+	r.topicLogging = prefix + r.topicLogging
+	r.topicMeter = prefix + r.topicMeter
+}
+
 func (r *kafkaReporter) Boot(service string, serviceInstance string, cdsWatchers []go2sky.AgentConfigChangeWatcher) {
 	r.service = service
 	r.serviceInstance = serviceInstance
 	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.initSendPipeline()
+	r.initSpoolReplay()
 	r.check()
+	r.initCDS(cdsWatchers)
 }
 
 func (r *kafkaReporter) reportInstanceProperties() error {
@@ -162,14 +353,24 @@ func (r *kafkaReporter) reportInstanceProperties() error {
 		return err
 	}
 
-	r.producer.Input() <- &sarama.ProducerMessage{
+	if !r.produce(&sarama.ProducerMessage{
 		Topic: r.topicManagement,
 		Key:   sarama.StringEncoder(topicKeyRegister + instanceProperties.ServiceInstance),
 		Value: sarama.ByteEncoder(b),
+	}) {
+		return r.ctx.Err()
 	}
 	return nil
 }
 
+func (r *kafkaReporter) submitInstanceProperties() bool {
+	if err := r.reportInstanceProperties(); err != nil {
+		r.logger.Printf("report serviceInstance properties error %v", err)
+		return false
+	}
+	return true
+}
+
 func (r *kafkaReporter) check() {
 	if r.checkInterval < 0 || r.producer == nil {
 		return
@@ -179,19 +380,26 @@ func (r *kafkaReporter) check() {
 		defer r.wg.Done()
 		ticker := time.NewTicker(r.checkInterval)
 		defer ticker.Stop()
-		instancePropertiesSubmitted := false
+		var propertiesC <-chan time.Time
+		if r.propertiesInterval > 0 {
+			propertiesTicker := time.NewTicker(r.propertiesInterval)
+			defer propertiesTicker.Stop()
+			propertiesC = propertiesTicker.C
+		}
+		// report the properties on boot, retry on the next check if failed
+		instancePropertiesSubmitted := r.submitInstanceProperties()
 		for {
 			select {
 			case <-r.ctx.Done():
 				return
+			case <-propertiesC:
+				// report again in case of the OAP restarted or the topic purged
+				instancePropertiesSubmitted = r.submitInstanceProperties()
 			case <-ticker.C:
 				if !instancePropertiesSubmitted {
-					err := r.reportInstanceProperties()
-					if err != nil {
-						r.logger.Printf("report serviceInstance properties error %v", err)
+					if instancePropertiesSubmitted = r.submitInstanceProperties(); !instancePropertiesSubmitted {
 						continue
 					}
-					instancePropertiesSubmitted = true
 				}
 
 				instancePingPkg := &managementv3.InstancePingPkg{
@@ -204,10 +412,18 @@ func (r *kafkaReporter) check() {
 					continue
 				}
 
-				r.producer.Input() <- &sarama.ProducerMessage{
+				if !r.produce(&sarama.ProducerMessage{
 					Topic: r.topicManagement,
 					Key:   sarama.StringEncoder(instancePingPkg.ServiceInstance),
 					Value: sarama.ByteEncoder(b),
+				}) {
+					return
+				}
+
+				if r.runtimeMetrics {
+					if err := r.reportRuntimeMetrics(); err != nil {
+						r.logger.Printf("report runtime metrics error %v", err)
+					}
 				}
 			}
 		}
@@ -219,6 +435,11 @@ func (r *kafkaReporter) Send(spans []go2sky.ReportedSpan) {
 	if spanSize < 1 {
 		return
 	}
+	if r.isClosed() {
+		atomic.AddUint64(&r.counter.dropped, 1)
+		r.logger.Printf("reporter segment closed")
+		return
+	}
 	rootSpan := spans[spanSize-1]
 	rootCtx := rootSpan.Context()
 	segmentObject := &agentv3.SegmentObject{
@@ -245,29 +466,27 @@ func (r *kafkaReporter) Send(spans []go2sky.ReportedSpan) {
 			Logs:          s.Logs(),
 		}
 		srr := make([]*agentv3.SegmentReference, 0)
-		if i == (spanSize-1) && spanCtx.ParentSpanID > -1 {
-			srr = append(srr, &agentv3.SegmentReference{
-				RefType:               agentv3.RefType_CrossThread,
-				TraceId:               spanCtx.TraceID,
-				ParentTraceSegmentId:  spanCtx.ParentSegmentID,
-				ParentSpanId:          spanCtx.ParentSpanID,
-				ParentService:         r.service,
-				ParentServiceInstance: r.serviceInstance,
-			})
+		// only the root span of an async segment refers to the parent segment in process
+		if i == (spanSize - 1) {
+			if ref := r.crossThreadRef(s); ref != nil {
+				srr = append(srr, ref)
+			}
 		}
-		if len(s.Refs()) > 0 {
-			for _, tc := range s.Refs() {
-				srr = append(srr, &agentv3.SegmentReference{
-					RefType:                  agentv3.RefType_CrossProcess,
-					TraceId:                  spanCtx.TraceID,
-					ParentTraceSegmentId:     tc.ParentSegmentID,
-					ParentSpanId:             tc.ParentSpanID,
-					ParentService:            tc.ParentService,
-					ParentServiceInstance:    tc.ParentServiceInstance,
-					ParentEndpoint:           tc.ParentEndpoint,
-					NetworkAddressUsedAtPeer: tc.AddressUsedAtClient,
-				})
+		for _, tc := range s.Refs() {
+			traceID := tc.TraceID
+			if traceID == "" {
+				traceID = spanCtx.TraceID
 			}
+			srr = append(srr, &agentv3.SegmentReference{
+				RefType:                  agentv3.RefType_CrossProcess,
+				TraceId:                  traceID,
+				ParentTraceSegmentId:     tc.ParentSegmentID,
+				ParentSpanId:             tc.ParentSpanID,
+				ParentService:            tc.ParentService,
+				ParentServiceInstance:    tc.ParentServiceInstance,
+				ParentEndpoint:           tc.ParentEndpoint,
+				NetworkAddressUsedAtPeer: tc.AddressUsedAtClient,
+			})
 		}
 		segmentObject.Spans[i].Refs = srr
 	}
@@ -277,25 +496,166 @@ func (r *kafkaReporter) Send(spans []go2sky.ReportedSpan) {
 		r.logger.Printf("reporter segment err %v", err)
 		return
 	}
-	select {
-	case <-r.ctx.Done():
-		r.logger.Printf("reporter segment closed")
-		return
-	default:
+	topic := r.topicSegment
+	if r.segmentRouter != nil {
+		if t := r.segmentRouter(segmentObject); t != "" {
+			topic = t
+		}
 	}
-	r.producer.Input() <- &sarama.ProducerMessage{
-		Topic: r.topicSegment,
-		Key:   sarama.StringEncoder(segmentObject.TraceSegmentId),
-		Value: sarama.ByteEncoder(b),
+	r.enqueue(&sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      sarama.StringEncoder(segmentObject.TraceSegmentId),
+		Value:    sarama.ByteEncoder(b),
+		Metadata: &messageMeta{segments: 1},
+	})
+}
+
+// crossThreadRef build the reference of the root span of an async segment,
+// which is created from the context of a finished segment in the same process.
+// Notice go2sky resets the parent span id of the root span to -1 without keeping it,
+// so the ref refers to the first span of the parent segment, which is an ancestor of
+// the real parent span, instead of the real parent span.
+func (r *kafkaReporter) crossThreadRef(rootSpan go2sky.ReportedSpan) *agentv3.SegmentReference {
+	spanCtx := rootSpan.Context()
+	if spanCtx.ParentSegmentID == "" || spanCtx.ParentSegmentID == spanCtx.SegmentID {
+		return nil
+	}
+
+	ref := &agentv3.SegmentReference{
+		RefType:               agentv3.RefType_CrossThread,
+		TraceId:               spanCtx.TraceID,
+		ParentTraceSegmentId:  spanCtx.ParentSegmentID,
+		ParentSpanId:          spanCtx.ParentSpanID,
+		ParentService:         r.service,
+		ParentServiceInstance: r.serviceInstance,
 	}
+	// the first span of the parent segment is inherited by the async segment
+	if first, ok := spanCtx.FirstSpan.(go2sky.ReportedSpan); ok && first != rootSpan {
+		ref.ParentEndpoint = first.OperationName()
+	}
+	if ref.ParentSpanId < 0 {
+		// the real parent span id is unknown, refer to the first span of the parent segment
+		ref.ParentSpanId = 0
+	}
+	return ref
 }
 
+// GracefulCloser is implemented by the kafka reporter created by New,
+// it can be used to close the reporter with a deadline.
+type GracefulCloser interface {
+	// CloseWithContext stops accepting new segments, sends the pending segments
+	// and closes the kafka producer, it returns when done or ctx is done.
+	// lost is the number of segments lost on closing, the segments still
+	// in flight of the kafka producer when ctx is done are not counted.
+	CloseWithContext(ctx context.Context) (lost uint64, err error)
+}
+
+var _ GracefulCloser = &kafkaReporter{}
+
+// Close the reporter, the pending segments are sent in defaultCloseTimeout
 func (r *kafkaReporter) Close() {
-	r.cancel()
-	r.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	if _, err := r.CloseWithContext(ctx); err != nil {
+		r.logger.Printf("close reporter error %v", err)
+	}
+}
+
+// CloseWithContext close the reporter gracefully
+func (r *kafkaReporter) CloseWithContext(ctx context.Context) (lost uint64, err error) {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return 0, errors.New("reporter already closed")
+	}
+	before := r.lostSegments()
+
+	if r.cancel != nil {
+		// send the pending segments until ctx is done
+		close(r.drainCh)
+		select {
+		case <-r.pipelineDone:
+		case <-ctx.Done():
+		}
+		// stop the background goroutines, the pipeline counts the segments it can not
+		// send any more as dropped and never blocks after that
+		r.cancel()
+		<-r.pipelineDone
+		// the segments enqueued after draining are lost
+		atomic.AddUint64(&r.counter.dropped, uint64(len(r.sendCh)))
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		// the producer input is closed only after no goroutine sends to it
+		r.wg.Wait()
+		r.closeProducer()
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	lost = r.lostSegments() - before
+	if lost > 0 {
+		r.logger.Printf("%d segments lost on closing", lost)
+	}
+	return lost, err
+}
+
+func (r *kafkaReporter) isClosed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+func (r *kafkaReporter) lostSegments() uint64 {
+	stats := r.Stats()
+	return stats.Dropped + stats.Failed
+}
+
+func (r *kafkaReporter) closeProducer() {
+	if r.producer == nil {
+		return
+	}
 	if err := r.producer.Close(); err != nil {
-		r.logger.Print(err)
+		// the errors not consumed yet are returned on closing
+		if errs, ok := err.(sarama.ProducerErrors); ok {
+			for _, e := range errs {
+				r.handleProducerError(e)
+			}
+		} else {
+			r.logger.Print(err)
+		}
+	}
+	if r.errorsDone != nil {
+		<-r.errorsDone
+	}
+	if r.spool != nil {
+		if err := r.spool.close(); err != nil {
+			r.logger.Print(err)
+		}
+	}
+}
+
+func (r *kafkaReporter) handleProducerError(e *sarama.ProducerError) {
+	r.logger.Printf("send kafka err: %v", e.Err)
+	if e.Msg == nil {
+		return
 	}
+	// only the segment messages carry the meta
+	meta, ok := e.Msg.Metadata.(*messageMeta)
+	if !ok {
+		return
+	}
+	if r.spool != nil {
+		err := r.spool.append(e.Msg)
+		if err == nil {
+			// the spooled segments are replayed later, they are not lost
+			atomic.AddUint64(&r.counter.spooled, uint64(meta.segments))
+			return
+		}
+		r.logger.Printf("spool segment error %v", err)
+	}
+	atomic.AddUint64(&r.counter.failed, uint64(meta.segments))
 }
 
 func buildOSInfo() (props []*commonv3.KeyStringValuePair) {
@@ -326,6 +686,37 @@ func buildOSInfo() (props []*commonv3.KeyStringValuePair) {
 	}
 	props = append(props, osName)
 
+	goVersion := &commonv3.KeyStringValuePair{
+		Key:   "Go Version",
+		Value: tool.GoVersion(),
+	}
+	props = append(props, goVersion)
+
+	maxProcs := &commonv3.KeyStringValuePair{
+		Key:   "GOMAXPROCS",
+		Value: tool.MaxProcs(),
+	}
+	props = append(props, maxProcs)
+
+	if path, version := tool.MainModule(); path != "" {
+		props = append(props, &commonv3.KeyStringValuePair{
+			Key:   "Module Path",
+			Value: path,
+		}, &commonv3.KeyStringValuePair{
+			Key:   "Module Version",
+			Value: version,
+		})
+	}
+
+	containerID := tool.ContainerID()
+	if containerID != "" {
+		kv := &commonv3.KeyStringValuePair{
+			Key:   "Container ID",
+			Value: containerID,
+		}
+		props = append(props, kv)
+	}
+
 	ipv4s := tool.AllIPV4()
 	if len(ipv4s) > 0 {
 		for _, ipv4 := range ipv4s {
@@ -17,9 +17,15 @@
 package kafkareporter
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,6 +36,7 @@ import (
 	"google.golang.org/protobuf/proto"
 	commonv3 "skywalking.apache.org/repo/goapi/collect/common/v3"
 	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
 	managementv3 "skywalking.apache.org/repo/goapi/collect/management/v3"
 )
 
@@ -100,6 +107,9 @@ func TestKafkaReporterE2E(t *testing.T) {
 	entrySpan.End()
 	for msg := range r.producer.Successes() {
 		r.Close()
+		if stats := r.Stats(); stats.Sent != 1 {
+			t.Errorf("Excepted sent segments is 1 not %d", stats.Sent)
+		}
 		if msg.Topic != r.topicSegment {
 			t.Errorf("Excepted kafka topic is %s not %s", r.topicSegment, msg.Topic)
 		}
@@ -219,6 +229,146 @@ func TestKafkaReporterOption(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:   "with topic logging",
+			option: WithTopicLogging("test_logging"),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.topicLogging != "test_logging" {
+					t.Error("error are not set WithTopicLogging")
+				}
+			},
+		},
+		{
+			name:   "with topic meter",
+			option: WithTopicMeter("test_meter"),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.topicMeter != "test_meter" {
+					t.Error("error are not set WithTopicMeter")
+				}
+			},
+		},
+		{
+			name:   "with runtime metrics",
+			option: WithRuntimeMetrics(true),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if !reporter.runtimeMetrics {
+					t.Error("error are not set WithRuntimeMetrics")
+				}
+			},
+		},
+		{
+			name:   "with cds interval",
+			option: WithCDSInterval(time.Second),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.cdsInterval != time.Second {
+					t.Error("error are not set WithCDSInterval")
+				}
+			},
+		},
+		{
+			name: "with config fetcher",
+			option: WithConfigFetcher(ConfigFetcherFunc(func(ctx context.Context, service string, uuid string) (*commonv3.Commands, error) {
+				return nil, nil
+			})),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.cdsFetcher == nil {
+					t.Error("error are not set WithConfigFetcher")
+				}
+			},
+		},
+		{
+			name:   "with send queue size",
+			option: WithSendQueueSize(10),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.sendQueueSize != 10 {
+					t.Error("error are not set WithSendQueueSize")
+				}
+			},
+		},
+		{
+			name:   "with drop policy",
+			option: WithDropPolicy(Block, time.Second),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.dropPolicy != Block || reporter.blockTimeout != time.Second {
+					t.Error("error are not set WithDropPolicy")
+				}
+			},
+		},
+		{
+			name:   "with spool",
+			option: WithSpool("spool", 1024, time.Hour),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.spoolDir != "spool" || reporter.spoolMaxSize != 1024 || reporter.spoolMaxAge != time.Hour {
+					t.Error("error are not set WithSpool")
+				}
+			},
+		},
+		{
+			name:   "with batch",
+			option: WithBatch(100, time.Second),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				reporter.c = sarama.NewConfig()
+				reporter.applyBatch()
+				flush := reporter.c.Producer.Flush
+				if flush.Messages != 100 || flush.MaxMessages != 100 || flush.Frequency != time.Second {
+					t.Error("error are not set WithBatch")
+				}
+			},
+		},
+		{
+			name:   "with batch without latency",
+			option: WithBatch(100, 0),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				reporter.c = sarama.NewConfig()
+				reporter.applyBatch()
+				if frequency := reporter.c.Producer.Flush.Frequency; frequency != defaultBatchLatency {
+					t.Errorf("flush frequency = %v, want %v", frequency, defaultBatchLatency)
+				}
+			},
+		},
+		{
+			name:   "with batch of negative latency",
+			option: WithBatch(100, -time.Second),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				reporter.c = sarama.NewConfig()
+				reporter.applyBatch()
+				if frequency := reporter.c.Producer.Flush.Frequency; frequency != defaultBatchLatency {
+					t.Errorf("flush frequency = %v, want %v", frequency, defaultBatchLatency)
+				}
+			},
+		},
+		{
+			name:   "with namespace",
+			option: WithNamespace("ns"),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				reporter.applyNamespace()
+				for _, topic := range []string{reporter.topicManagement, reporter.topicSegment, reporter.topicLogging, reporter.topicMeter} {
+					if !strings.HasPrefix(topic, "ns-") {
+						t.Errorf("error are not set WithNamespace to topic %s", topic)
+					}
+				}
+			},
+		},
+		{
+			name: "with segment router",
+			option: WithSegmentRouter(func(segment *agentv3.SegmentObject) string {
+				return ""
+			}),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.segmentRouter == nil {
+					t.Error("error are not set WithSegmentRouter")
+				}
+			},
+		},
+		{
+			name:   "with properties report interval",
+			option: WithPropertiesReportInterval(time.Minute),
+			verifyFunc: func(t *testing.T, reporter *kafkaReporter) {
+				if reporter.propertiesInterval != time.Minute {
+					t.Error("error are not set WithPropertiesReportInterval")
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -276,6 +426,924 @@ func TestKafkaReporter_reportInstanceProperties(t *testing.T) {
 		if len(s.Properties) != len(osProps) {
 			t.Error("error are not set service Properties")
 		}
+		var goVersion string
+		for _, p := range s.Properties {
+			if p.Key == "Go Version" {
+				goVersion = p.Value
+			}
+		}
+		if goVersion != runtime.Version() {
+			t.Error("error are not set go version Properties")
+		}
+	}
+}
+
+func TestKafkaReporter_SendLog(t *testing.T) {
+	r := createKafkaReporter()
+	tracer, err := go2sky.NewTracer(mockService, go2sky.WithReporter(r), go2sky.WithInstance(mockServiceInstance))
+	if err != nil {
+		t.Error(err)
+	}
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	r.producer = mp
+
+	span, ctx, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	defer span.End()
+	r.SendLog(ctx, &logv3.LogData{
+		Body: &logv3.LogDataBody{
+			Content: &logv3.LogDataBody_Text{Text: &logv3.TextLog{Text: "test"}},
+		},
+	})
+	for msg := range r.producer.Successes() {
+		r.Close()
+		if msg.Topic != r.topicLogging {
+			t.Errorf("Excepted kafka topic is %s not %s", r.topicLogging, msg.Topic)
+		}
+		v, _ := msg.Value.Encode()
+		var l logv3.LogData
+		if err := proto.Unmarshal(v, &l); err != nil {
+			t.Fatal(err)
+		}
+		if l.Service != mockService {
+			t.Error("error are not set service")
+		}
+		if l.ServiceInstance != mockServiceInstance {
+			t.Error("error are not set service instance")
+		}
+		if l.Timestamp == 0 {
+			t.Error("error are not set timestamp")
+		}
+		if l.GetTraceContext().GetTraceId() != go2sky.TraceID(ctx) {
+			t.Error("error are not set trace context")
+		}
+		if l.GetBody().GetText().GetText() != "test" {
+			t.Error("error are not set log body")
+		}
+	}
+}
+
+// discardProducer consumes and discards the input, the input is closed on closing like sarama
+type discardProducer struct {
+	input chan *sarama.ProducerMessage
+	done  chan struct{}
+}
+
+func newDiscardProducer() *discardProducer {
+	p := &discardProducer{
+		input: make(chan *sarama.ProducerMessage),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		for range p.input {
+		}
+	}()
+	return p
+}
+
+func (p *discardProducer) AsyncClose() {
+	close(p.input)
+}
+
+func (p *discardProducer) Close() error {
+	p.AsyncClose()
+	<-p.done
+	return nil
+}
+
+func (p *discardProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *discardProducer) Successes() <-chan *sarama.ProducerMessage {
+	return nil
+}
+
+func (p *discardProducer) Errors() <-chan *sarama.ProducerError {
+	return nil
+}
+
+func TestKafkaReporter_SendLogNonBlocking(t *testing.T) {
+	reporter := createKafkaReporter()
+	var output bytes.Buffer
+	reporter.logger = log.New(&output, "", 0)
+	reporter.checkInterval = -1
+	reporter.logCh = make(chan *sarama.ProducerMessage, 1)
+	reporter.producer = &stuckProducer{input: make(chan *sarama.ProducerMessage)}
+	reporter.Boot(mockService, mockServiceInstance, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			reporter.SendLog(context.Background(), &logv3.LogData{})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("error are blocked by the stuck producer")
+	}
+	// one log is taken by the pipeline and one is queued
+	if dropped := reporter.Stats().DroppedLogs; dropped < 8 {
+		t.Errorf("Excepted dropped logs is at least 8 not %d", dropped)
+	}
+	if output.Len() != 0 {
+		t.Errorf("the dropped logs are logged: %s", output.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := reporter.CloseWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Excepted error is %v not %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestKafkaReporter_SendLogOnClosing(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.logger = log.New(ioutil.Discard, "", 0)
+	reporter.checkInterval = -1
+	reporter.producer = newDiscardProducer()
+	reporter.Boot(mockService, mockServiceInstance, nil)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				// sending on the closed producer input panics
+				reporter.SendLog(context.Background(), &logv3.LogData{})
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := reporter.CloseWithContext(ctx); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	<-done
+	if reporter.Stats().DroppedLogs == 0 {
+		t.Error("the logs sent after closing are not counted as dropped")
+	}
+}
+
+func TestKafkaReporter_reportRuntimeMetrics(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.service = mockService
+	reporter.serviceInstance = mockServiceInstance
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	reporter.producer = mp
+
+	err := reporter.reportRuntimeMetrics()
+	if err != nil {
+		t.Error(err)
+	}
+	for msg := range reporter.producer.Successes() {
+		_ = reporter.producer.Close()
+		if msg.Topic != reporter.topicMeter {
+			t.Errorf("Excepted kafka topic is %s not %s", reporter.topicMeter, msg.Topic)
+		}
+		v, _ := msg.Value.Encode()
+		var s agentv3.MeterDataCollection
+		if err := proto.Unmarshal(v, &s); err != nil {
+			t.Fatal(err)
+		}
+		meters := make(map[string]float64)
+		for _, m := range s.MeterData {
+			if m.Service != mockService {
+				t.Error("error are not set service")
+			}
+			if m.ServiceInstance != mockServiceInstance {
+				t.Error("error are not set service instance")
+			}
+			meters[m.GetSingleValue().GetName()] = m.GetSingleValue().GetValue()
+		}
+		if meters[meterHeapAlloc] <= 0 {
+			t.Error("error are not report heap alloc")
+		}
+		if meters[meterLiveGoroutines] <= 0 {
+			t.Error("error are not report live goroutines")
+		}
+		if _, ok := meters[meterGCPauseTime]; !ok {
+			t.Error("error are not report gc pause time")
+		}
+	}
+}
+
+type mockWatcher struct {
+	key    string
+	value  string
+	events []go2sky.AgentConfigEventType
+}
+
+func (w *mockWatcher) Key() string {
+	return w.key
+}
+
+func (w *mockWatcher) Value() string {
+	return w.value
+}
+
+func (w *mockWatcher) Notify(eventType go2sky.AgentConfigEventType, newValue string) {
+	w.events = append(w.events, eventType)
+	w.value = newValue
+}
+
+func TestKafkaReporter_fetchConfigurations(t *testing.T) {
+	var fetchedUUIDs []string
+	fetcher := ConfigFetcherFunc(func(ctx context.Context, service string, uuid string) (*commonv3.Commands, error) {
+		if service != mockService {
+			t.Errorf("Excepted service is %s not %s", mockService, service)
+		}
+		fetchedUUIDs = append(fetchedUUIDs, uuid)
+		return &commonv3.Commands{
+			Commands: []*commonv3.Command{
+				{
+					Command: cdsCommand,
+					Args: []*commonv3.KeyStringValuePair{
+						{Key: "UUID", Value: "uuid-1"},
+						{Key: "agent.sample_rate", Value: "0.5"},
+					},
+				},
+			},
+		}, nil
+	})
+
+	reporter := createKafkaReporter()
+	reporter.service = mockService
+	reporter.cdsFetcher = fetcher
+	reporter.cdsInterval = time.Hour
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+	watcher := &mockWatcher{key: "agent.sample_rate", value: "1"}
+	reporter.initCDS([]go2sky.AgentConfigChangeWatcher{watcher})
+	reporter.cancel()
+	reporter.wg.Wait()
+
+	reporter.fetchConfigurations()
+	if len(fetchedUUIDs) != 2 || fetchedUUIDs[0] != "" || fetchedUUIDs[1] != "uuid-1" {
+		t.Errorf("error are not fetch with uuid %v", fetchedUUIDs)
+	}
+	if len(watcher.events) != 1 || watcher.events[0] != go2sky.MODIFY {
+		t.Errorf("error are not notify watcher %v", watcher.events)
+	}
+	if watcher.value != "0.5" {
+		t.Errorf("error are not set watcher value %s", watcher.value)
+	}
+}
+
+func TestKafkaReporter_enqueue(t *testing.T) {
+	oldest := &sarama.ProducerMessage{Key: sarama.StringEncoder("oldest")}
+	newest := &sarama.ProducerMessage{Key: sarama.StringEncoder("newest")}
+
+	tests := []struct {
+		name     string
+		policy   DropPolicy
+		expected *sarama.ProducerMessage
+	}{
+		{name: "drop newest", policy: DropNewest, expected: oldest},
+		{name: "drop oldest", policy: DropOldest, expected: newest},
+		{name: "block with timeout", policy: Block, expected: oldest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := createKafkaReporter()
+			reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+			defer reporter.cancel()
+			reporter.sendCh = make(chan *sarama.ProducerMessage, 1)
+			reporter.dropPolicy = tt.policy
+			reporter.blockTimeout = time.Millisecond
+
+			reporter.enqueue(oldest)
+			reporter.enqueue(newest)
+			if msg := <-reporter.sendCh; msg != tt.expected {
+				t.Errorf("Excepted message is %v not %v", tt.expected.Key, msg.Key)
+			}
+			if stats := reporter.Stats(); stats.Dropped != 1 {
+				t.Errorf("Excepted dropped segments is 1 not %d", stats.Dropped)
+			}
+		})
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go2sky-kafka-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := &sarama.ProducerMessage{
+		Topic:    defaultTopicSegment,
+		Value:    sarama.StringEncoder("expired"),
+		Metadata: &messageMeta{segments: 1, spooledAt: time.Now().Add(-2 * time.Hour)},
+	}
+	for _, msg := range []*sarama.ProducerMessage{
+		{Topic: defaultTopicSegment, Key: sarama.StringEncoder("1"), Value: sarama.StringEncoder("segment1")},
+		expired,
+		{Topic: defaultTopicSegment, Key: sarama.StringEncoder("2"), Value: sarama.StringEncoder("segment2")},
+	} {
+		if err = s.append(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spooled segments are kept after restarting
+	s, err = newSpool(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	records := readSpool(t, s)
+	if len(records) != 3 || !records[1].expired || records[2].end != s.size {
+		t.Fatalf("error are not read all the spooled segments %v", records)
+	}
+	for i, record := range []spoolRecord{records[0], records[2]} {
+		msg := record.msg
+		key, _ := msg.Key.Encode()
+		value, _ := msg.Value.Encode()
+		if msg.Topic != defaultTopicSegment || string(key) != fmt.Sprint(i+1) || string(value) != fmt.Sprintf("segment%d", i+1) {
+			t.Errorf("error are not replay segment in order %s %s %s", msg.Topic, key, value)
+		}
+	}
+	// the segments are not removed until discarded
+	if again := readSpool(t, s); len(again) != 3 {
+		t.Errorf("Excepted spooled segments is 3 not %d", len(again))
+	}
+
+	// the segments appended after reading are kept on discarding
+	if err = s.append(&sarama.ProducerMessage{Topic: defaultTopicSegment, Key: sarama.StringEncoder("3"), Value: sarama.StringEncoder("segment3")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.discard(records[0].end); err != nil {
+		t.Fatal(err)
+	}
+	if records = readSpool(t, s); len(records) != 3 {
+		t.Fatalf("Excepted spooled segments is 3 not %d", len(records))
+	}
+	if value, _ := records[2].msg.Value.Encode(); string(value) != "segment3" {
+		t.Errorf("error are not keep the appended segment %s", value)
+	}
+	if err = s.discard(s.size); err != nil {
+		t.Fatal(err)
+	}
+	if records = readSpool(t, s); len(records) != 0 {
+		t.Error("error are not empty the spool")
+	}
+	// the spool is truncated instead of rewritten if all the segments are discarded
+	if info, err := s.file.Stat(); err != nil || info.Size() != 0 {
+		t.Errorf("error are not truncate the spool %v %v", info, err)
+	}
+
+	// the segments exceed the max size are dropped
+	s.maxSize = 1
+	if err = s.append(&sarama.ProducerMessage{Topic: defaultTopicSegment, Value: sarama.StringEncoder("segment")}); err == nil {
+		t.Error("error are not limit the spool size")
+	}
+}
+
+func TestKafkaReporter_replaySpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go2sky-kafka-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reporter := createKafkaReporter()
+	if reporter.spool, err = newSpool(dir, 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer reporter.spool.close()
+	// the failed segments are spooled instead of lost
+	for _, id := range []string{"segment1", "segment2"} {
+		reporter.handleProducerError(&sarama.ProducerError{
+			Msg: &sarama.ProducerMessage{
+				Topic:    reporter.topicSegment,
+				Value:    sarama.StringEncoder(id),
+				Metadata: &messageMeta{segments: 1},
+			},
+			Err: sarama.ErrOutOfBrokers,
+		})
+	}
+	if stats := reporter.Stats(); stats.Spooled != 2 || stats.Failed != 0 || reporter.lostSegments() != 0 {
+		t.Fatalf("error are not count the spooled segments %+v", stats)
+	}
+
+	// the spool is kept if the segments can not be handed over
+	reporter.producer = &stuckProducer{input: make(chan *sarama.ProducerMessage)}
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+	reporter.cancel()
+	reporter.replaySpool()
+	if records := readSpool(t, reporter.spool); len(records) != 2 {
+		t.Fatalf("Excepted spooled segments is 2 not %d", len(records))
+	}
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	mp.ExpectInputAndSucceed()
+	reporter.producer = mp
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+	defer reporter.cancel()
+	reporter.replaySpool()
+	for i := 1; i <= 2; i++ {
+		msg := <-mp.Successes()
+		if value, _ := msg.Value.Encode(); string(value) != fmt.Sprintf("segment%d", i) {
+			t.Errorf("error are not replay segment in order %s", value)
+		}
+	}
+	_ = mp.Close()
+	if records := readSpool(t, reporter.spool); len(records) != 0 {
+		t.Errorf("error are not remove the replayed segments %d", len(records))
+	}
+	if stats := reporter.Stats(); stats.Sent != 2 || reporter.lostSegments() != 0 {
+		t.Errorf("error are not count the replayed segments %+v", stats)
+	}
+}
+
+func TestKafkaReporter_replaySpoolCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go2sky-kafka-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reporter := createKafkaReporter()
+	reporter.logger = log.New(ioutil.Discard, "", 0)
+	if reporter.spool, err = newSpool(dir, 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer reporter.spool.close()
+	if err = reporter.spool.append(&sarama.ProducerMessage{Topic: reporter.topicSegment, Value: sarama.StringEncoder("segment1")}); err != nil {
+		t.Fatal(err)
+	}
+	// the record written partially on crash
+	if _, err = reporter.spool.file.Write([]byte{0x7f, 0x0a}); err != nil {
+		t.Fatal(err)
+	}
+	reporter.spool.size += 2
+	record, err := reporter.spool.next(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reporter.spool.next(record.end); err != errSpoolCorrupted {
+		t.Fatalf("error are not detect the corrupted record %v", err)
+	}
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	reporter.producer = mp
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+	defer reporter.cancel()
+	reporter.replaySpool()
+	if msg := <-mp.Successes(); msg.Value == nil {
+		t.Error("error are not replay the segment before the corrupted record")
+	}
+	_ = mp.Close()
+	if reporter.spool.size != 0 {
+		t.Errorf("error are not skip the corrupted record %d", reporter.spool.size)
+	}
+}
+
+// readSpool reads all the records of the spool in order
+func readSpool(t *testing.T, s *spool) []spoolRecord {
+	var records []spoolRecord
+	var offset int64
+	for {
+		record, err := s.next(offset)
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+		offset = record.end
+	}
+}
+
+func TestKafkaReporter_batch(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.batchSize = 2
+	reporter.batchLatency = time.Hour
+	reporter.c = sarama.NewConfig()
+	reporter.applyBatch()
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	mp.ExpectInputAndSucceed()
+	reporter.producer = mp
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
+	reporter.initSendPipeline()
+
+	for _, id := range []string{"segment1", "segment2"} {
+		b, err := proto.Marshal(&agentv3.SegmentObject{TraceId: traceID, TraceSegmentId: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reporter.enqueue(&sarama.ProducerMessage{
+			Topic:    reporter.topicSegment,
+			Key:      sarama.StringEncoder(id),
+			Value:    sarama.ByteEncoder(b),
+			Metadata: &messageMeta{segments: 1},
+		})
+	}
+
+	// every segment is one record the OAP kafka fetcher can decode
+	for i := 1; i <= 2; i++ {
+		msg := <-reporter.producer.Successes()
+		v, _ := msg.Value.Encode()
+		var segment agentv3.SegmentObject
+		if err := proto.Unmarshal(v, &segment); err != nil {
+			t.Fatal(err)
+		}
+		if segment.TraceSegmentId != fmt.Sprintf("segment%d", i) {
+			t.Errorf("error are not send segment in order %s", segment.TraceSegmentId)
+		}
+	}
+	reporter.cancel()
+	reporter.wg.Wait()
+	_ = reporter.producer.Close()
+	if stats := reporter.Stats(); stats.Sent != 2 {
+		t.Errorf("Excepted sent segments is 2 not %d", stats.Sent)
+	}
+}
+
+func TestKafkaReporter_segmentRouter(t *testing.T) {
+	r := createKafkaReporter()
+	r.segmentRouter = func(segment *agentv3.SegmentObject) string {
+		if segment.Service == mockService {
+			return "tenant-skywalking-segments"
+		}
+		return ""
+	}
+	tracer, err := go2sky.NewTracer(mockService, go2sky.WithReporter(r), go2sky.WithInstance(mockServiceInstance))
+	if err != nil {
+		t.Error(err)
+	}
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	r.producer = mp
+
+	span, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	span.End()
+	for msg := range r.producer.Successes() {
+		r.Close()
+		if msg.Topic != "tenant-skywalking-segments" {
+			t.Errorf("Excepted kafka topic is tenant-skywalking-segments not %s", msg.Topic)
+		}
+	}
+}
+
+func TestKafkaReporter_reportInstancePropertiesOnBoot(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.checkInterval = time.Hour
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	mp.ExpectInputAndSucceed()
+	reporter.producer = mp
+
+	reporter.Boot(mockService, mockServiceInstance, nil)
+	msg := <-reporter.producer.Successes()
+	reporter.Close()
+	if msg.Topic != reporter.topicManagement {
+		t.Errorf("Excepted kafka topic is %s not %s", reporter.topicManagement, msg.Topic)
+	}
+	key, _ := msg.Key.Encode()
+	if string(key) != topicKeyRegister+mockServiceInstance {
+		t.Errorf("error are not report serviceInstance properties on boot, key %s", key)
+	}
+}
+
+// stuckProducer never consumes the input like an unavailable kafka
+type stuckProducer struct {
+	input chan *sarama.ProducerMessage
+}
+
+func (p *stuckProducer) AsyncClose() {}
+
+func (p *stuckProducer) Close() error {
+	select {}
+}
+
+func (p *stuckProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *stuckProducer) Successes() <-chan *sarama.ProducerMessage {
+	return nil
+}
+
+func (p *stuckProducer) Errors() <-chan *sarama.ProducerError {
+	return nil
+}
+
+func TestKafkaReporter_CloseWithContext(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.checkInterval = -1
+
+	c := mocks.NewTestConfig()
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = false
+	mp := mocks.NewAsyncProducer(t, c)
+	for i := 0; i < 3; i++ {
+		mp.ExpectInputAndSucceed()
+	}
+	reporter.producer = mp
+	reporter.Boot(mockService, mockServiceInstance, nil)
+
+	for i := 0; i < 3; i++ {
+		reporter.enqueue(&sarama.ProducerMessage{
+			Topic:    reporter.topicSegment,
+			Value:    sarama.ByteEncoder{},
+			Metadata: &messageMeta{segments: 1},
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lost, err := reporter.CloseWithContext(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if lost != 0 {
+		t.Errorf("Excepted lost segments is 0 not %d", lost)
+	}
+	if stats := reporter.Stats(); stats.Sent != 3 {
+		t.Errorf("Excepted sent segments is 3 not %d", stats.Sent)
+	}
+
+	// new segments are not accepted after closing
+	reporter.Send([]go2sky.ReportedSpan{nil})
+	if stats := reporter.Stats(); stats.Dropped != 1 {
+		t.Errorf("Excepted dropped segments is 1 not %d", stats.Dropped)
+	}
+	if _, err = reporter.CloseWithContext(ctx); err == nil {
+		t.Error("error are not return on closing twice")
+	}
+}
+
+func TestKafkaReporter_CloseWithContextDeadline(t *testing.T) {
+	reporter := createKafkaReporter()
+	reporter.checkInterval = -1
+	reporter.producer = &stuckProducer{input: make(chan *sarama.ProducerMessage)}
+	reporter.Boot(mockService, mockServiceInstance, nil)
+
+	for i := 0; i < 3; i++ {
+		reporter.enqueue(&sarama.ProducerMessage{
+			Topic:    reporter.topicSegment,
+			Value:    sarama.ByteEncoder{},
+			Metadata: &messageMeta{segments: 1},
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	lost, err := reporter.CloseWithContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Excepted error is %v not %v", context.DeadlineExceeded, err)
+	}
+	if lost != 3 {
+		t.Errorf("Excepted lost segments is 3 not %d", lost)
+	}
+}
+
+func TestKafkaReporter_CloseWithContextDeadlineOnCheck(t *testing.T) {
+	reporter := createKafkaReporter()
+	// the check loop is blocked on sending the instance properties
+	reporter.checkInterval = 10 * time.Millisecond
+	reporter.runtimeMetrics = true
+	reporter.producer = &stuckProducer{input: make(chan *sarama.ProducerMessage)}
+	reporter.Boot(mockService, mockServiceInstance, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := reporter.CloseWithContext(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Excepted error is %v not %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close is not returned when ctx is done")
+	}
+}
+
+func TestKafkaReporter_segmentRefs(t *testing.T) {
+	// nextSegment returns the next segment sent to kafka
+	type nextSegment func() *agentv3.SegmentObject
+
+	extractor := func(key string) (string, error) {
+		if key == propagation.Header {
+			return header, nil
+		}
+		return "", nil
+	}
+	crossProcessRef := &agentv3.SegmentReference{
+		RefType:                  agentv3.RefType_CrossProcess,
+		TraceId:                  traceID,
+		ParentTraceSegmentId:     parentSegmentID,
+		ParentSpanId:             parentSpanID,
+		ParentService:            parentService,
+		ParentServiceInstance:    parentServiceInstance,
+		ParentEndpoint:           parentEndpoint,
+		NetworkAddressUsedAtPeer: addressUsedAtClient,
+	}
+
+	tests := []struct {
+		name     string
+		segments int
+		scenario func(t *testing.T, tracer *go2sky.Tracer, next nextSegment) (*agentv3.SegmentObject, []*agentv3.SegmentReference)
+	}{
+		{
+			name:     "root span without refs",
+			segments: 1,
+			scenario: func(t *testing.T, tracer *go2sky.Tracer, next nextSegment) (*agentv3.SegmentObject, []*agentv3.SegmentReference) {
+				span, _, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("/local"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				span.End()
+				return next(), nil
+			},
+		},
+		{
+			name:     "entry span with cross process ref",
+			segments: 1,
+			scenario: func(t *testing.T, tracer *go2sky.Tracer, next nextSegment) (*agentv3.SegmentObject, []*agentv3.SegmentReference) {
+				span, _, err := tracer.CreateEntrySpan(context.Background(), "/rest/api", extractor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				span.End()
+				return next(), []*agentv3.SegmentReference{crossProcessRef}
+			},
+		},
+		{
+			name:     "async local span with cross thread ref",
+			segments: 2,
+			scenario: func(t *testing.T, tracer *go2sky.Tracer, next nextSegment) (*agentv3.SegmentObject, []*agentv3.SegmentReference) {
+				entry, ctx, err := tracer.CreateEntrySpan(context.Background(), "/rest/api", extractor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				local, ctx, err := tracer.CreateLocalSpan(ctx, go2sky.WithOperationName("/local"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				parentSegment := go2sky.TraceSegmentID(ctx)
+				local.End()
+				entry.End()
+				// the async span is created after the parent segment finished
+				next()
+
+				async, _, err := tracer.CreateLocalSpan(ctx, go2sky.WithOperationName("/async"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				async.End()
+				// the real parent is /local(span 1), but go2sky does not keep
+				// its id, so the first span of the parent segment is referred
+				return next(), []*agentv3.SegmentReference{
+					{
+						RefType:               agentv3.RefType_CrossThread,
+						TraceId:               traceID,
+						ParentTraceSegmentId:  parentSegment,
+						ParentSpanId:          0,
+						ParentService:         mockService,
+						ParentServiceInstance: mockServiceInstance,
+						ParentEndpoint:        "/rest/api",
+					},
+				}
+			},
+		},
+		{
+			name:     "async entry span with cross thread and cross process refs",
+			segments: 2,
+			scenario: func(t *testing.T, tracer *go2sky.Tracer, next nextSegment) (*agentv3.SegmentObject, []*agentv3.SegmentReference) {
+				local, ctx, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("/local"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				parentTraceID := go2sky.TraceID(ctx)
+				parentSegment := go2sky.TraceSegmentID(ctx)
+				local.End()
+				// the async span is created after the parent segment finished
+				next()
+
+				async, _, err := tracer.CreateEntrySpan(ctx, "/async", extractor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				async.End()
+				return next(), []*agentv3.SegmentReference{
+					{
+						RefType:               agentv3.RefType_CrossThread,
+						TraceId:               parentTraceID,
+						ParentTraceSegmentId:  parentSegment,
+						ParentSpanId:          0,
+						ParentService:         mockService,
+						ParentServiceInstance: mockServiceInstance,
+						ParentEndpoint:        "/local",
+					},
+					crossProcessRef,
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := createKafkaReporter()
+			tracer, err := go2sky.NewTracer(mockService, go2sky.WithReporter(r), go2sky.WithInstance(mockServiceInstance))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := mocks.NewTestConfig()
+			c.Producer.Return.Successes = true
+			c.Producer.Return.Errors = false
+			mp := mocks.NewAsyncProducer(t, c)
+			for i := 0; i < tt.segments; i++ {
+				mp.ExpectInputAndSucceed()
+			}
+			r.producer = mp
+			defer r.Close()
+
+			next := func() *agentv3.SegmentObject {
+				msg := <-r.producer.Successes()
+				if msg.Topic != r.topicSegment {
+					t.Errorf("Excepted kafka topic is %s not %s", r.topicSegment, msg.Topic)
+				}
+				v, _ := msg.Value.Encode()
+				var s agentv3.SegmentObject
+				if err := proto.Unmarshal(v, &s); err != nil {
+					t.Fatal(err)
+				}
+				return &s
+			}
+			segment, expected := tt.scenario(t, tracer, next)
+
+			rootSpan := segment.Spans[len(segment.Spans)-1]
+			if len(rootSpan.Refs) != len(expected) {
+				t.Fatalf("Excepted refs is %d not %d", len(expected), len(rootSpan.Refs))
+			}
+			for i, ref := range rootSpan.Refs {
+				if !proto.Equal(ref, expected[i]) {
+					t.Errorf("Excepted ref is %v not %v", expected[i], ref)
+				}
+			}
+			for _, span := range segment.Spans[:len(segment.Spans)-1] {
+				if len(span.Refs) != 0 {
+					t.Errorf("error are set refs to the span %s", span.OperationName)
+				}
+			}
+		})
 	}
 }
 
@@ -284,6 +1352,12 @@ func createKafkaReporter() *kafkaReporter {
 		logger:          log.New(os.Stderr, "go2sky", log.LstdFlags),
 		topicManagement: defaultTopicManagement,
 		topicSegment:    defaultTopicSegment,
+		topicLogging:    defaultTopicLogging,
+		topicMeter:      defaultTopicMeter,
+		metricsSampler:  &runtimeSampler{},
+		sendCh:          make(chan *sarama.ProducerMessage, defaultMaxSendQueueSize),
+		logCh:           make(chan *sarama.ProducerMessage, defaultMaxSendQueueSize),
 	}
+	reporter.ctx, reporter.cancel = context.WithCancel(context.Background())
 	return reporter
 }
@@ -0,0 +1,84 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafkareporter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/SkyAPM/go2sky"
+	"google.golang.org/protobuf/proto"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
+)
+
+// LogReporter is implemented by the kafka reporter created by New,
+// it can be used by logging plugins to send logs to SkyWalking.
+type LogReporter interface {
+	SendLog(ctx context.Context, logData *logv3.LogData)
+}
+
+var _ LogReporter = &kafkaReporter{}
+
+// SendLog send log data to the logging topic, the service, service instance,
+// timestamp and trace context are filled in if they are absent.
+// The log is queued without blocking, it is dropped and counted in Stats if the queue is full.
+func (r *kafkaReporter) SendLog(ctx context.Context, logData *logv3.LogData) {
+	if logData == nil {
+		return
+	}
+	if logData.Service == "" {
+		logData.Service = r.service
+	}
+	if logData.ServiceInstance == "" {
+		logData.ServiceInstance = r.serviceInstance
+	}
+	if logData.Timestamp == 0 {
+		logData.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if logData.TraceContext == nil && ctx != nil {
+		if traceID := go2sky.TraceID(ctx); traceID != go2sky.EmptyTraceID {
+			logData.TraceContext = &logv3.TraceContext{
+				TraceId:        traceID,
+				TraceSegmentId: go2sky.TraceSegmentID(ctx),
+				SpanId:         go2sky.SpanID(ctx),
+			}
+		}
+	}
+
+	b, err := proto.Marshal(logData)
+	if err != nil {
+		r.logger.Printf("reporter log err %v", err)
+		return
+	}
+	// the dropped logs are counted only, logging them floods the output when kafka is slow
+	if r.isClosed() {
+		atomic.AddUint64(&r.counter.droppedLogs, 1)
+		return
+	}
+	// the log is sent by the send pipeline, the caller is never blocked
+	select {
+	case r.logCh <- &sarama.ProducerMessage{
+		Topic: r.topicLogging,
+		Key:   sarama.StringEncoder(logData.ServiceInstance),
+		Value: sarama.ByteEncoder(b),
+	}:
+	default:
+		atomic.AddUint64(&r.counter.droppedLogs, 1)
+	}
+}
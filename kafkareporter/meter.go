@@ -0,0 +1,100 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafkareporter
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/proto"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+const (
+	meterHeapAlloc      = "instance_golang_heap_alloc"
+	meterHeapInuse      = "instance_golang_heap_inuse"
+	meterHeapObjects    = "instance_golang_heap_objects"
+	meterStackUsed      = "instance_golang_stack_used"
+	meterSysMemory      = "instance_golang_sys_memory"
+	meterLiveGoroutines = "instance_golang_live_goroutines_num"
+	meterGCCount        = "instance_golang_gc_count"
+	meterGCPauseTime    = "instance_golang_gc_pause_time"
+)
+
+// runtimeSampler samples the go runtime metrics, the GC metrics are
+// reported as the increment since the last sampling.
+type runtimeSampler struct {
+	lastNumGC      uint32
+	lastPauseTotal uint64
+}
+
+func (s *runtimeSampler) sample() map[string]float64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gcCount := ms.NumGC - s.lastNumGC
+	gcPause := ms.PauseTotalNs - s.lastPauseTotal
+	s.lastNumGC = ms.NumGC
+	s.lastPauseTotal = ms.PauseTotalNs
+
+	return map[string]float64{
+		meterHeapAlloc:      float64(ms.HeapAlloc),
+		meterHeapInuse:      float64(ms.HeapInuse),
+		meterHeapObjects:    float64(ms.HeapObjects),
+		meterStackUsed:      float64(ms.StackInuse),
+		meterSysMemory:      float64(ms.Sys),
+		meterLiveGoroutines: float64(runtime.NumGoroutine()),
+		meterGCCount:        float64(gcCount),
+		meterGCPauseTime:    float64(gcPause) / float64(time.Millisecond),
+	}
+}
+
+func (r *kafkaReporter) reportRuntimeMetrics() error {
+	values := r.metricsSampler.sample()
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+
+	collection := &agentv3.MeterDataCollection{
+		MeterData: make([]*agentv3.MeterData, 0, len(values)),
+	}
+	for name, value := range values {
+		collection.MeterData = append(collection.MeterData, &agentv3.MeterData{
+			Metric: &agentv3.MeterData_SingleValue{
+				SingleValue: &agentv3.MeterSingleValue{
+					Name:  name,
+					Value: value,
+				},
+			},
+			Service:         r.service,
+			ServiceInstance: r.serviceInstance,
+			Timestamp:       timestamp,
+		})
+	}
+	b, err := proto.Marshal(collection)
+	if err != nil {
+		return err
+	}
+
+	if !r.produce(&sarama.ProducerMessage{
+		Topic: r.topicMeter,
+		Key:   sarama.StringEncoder(r.serviceInstance),
+		Value: sarama.ByteEncoder(b),
+	}) {
+		return r.ctx.Err()
+	}
+	return nil
+}
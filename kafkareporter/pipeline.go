@@ -0,0 +1,181 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafkareporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DropPolicy is the policy to apply when the send queue is full
+type DropPolicy int
+
+const (
+	// DropNewest drop the segment being sent
+	DropNewest DropPolicy = iota
+	// DropOldest drop the oldest segment in the queue to make room for the segment being sent
+	DropOldest
+	// Block wait for the queue until the block timeout, then drop the segment being sent
+	Block
+)
+
+// Stats is the statistics of the segments and logs handled by the kafka reporter
+type Stats struct {
+	// Sent is the number of segments handed over to the kafka producer, including the replayed ones
+	Sent uint64
+	// Dropped is the number of segments dropped before sending
+	Dropped uint64
+	// Failed is the number of segments failed to send by the kafka producer and not spooled,
+	// it's counted only if sarama.Config.Producer.Return.Errors is set
+	Failed uint64
+	// Spooled is the number of segments failed to send and persisted in the spool for replaying
+	Spooled uint64
+	// DroppedLogs is the number of logs dropped because the log queue is full or the reporter is closed
+	DroppedLogs uint64
+}
+
+// StatsReporter is implemented by the kafka reporter created by New,
+// it can be used to monitor the reporter.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+var _ StatsReporter = &kafkaReporter{}
+
+type segmentCounter struct {
+	sent    uint64
+	dropped uint64
+	failed  uint64
+	spooled uint64
+
+	droppedLogs uint64
+}
+
+// Stats returns the statistics of the segments and logs
+func (r *kafkaReporter) Stats() Stats {
+	return Stats{
+		Sent:        atomic.LoadUint64(&r.counter.sent),
+		Dropped:     atomic.LoadUint64(&r.counter.dropped),
+		Failed:      atomic.LoadUint64(&r.counter.failed),
+		Spooled:     atomic.LoadUint64(&r.counter.spooled),
+		DroppedLogs: atomic.LoadUint64(&r.counter.droppedLogs),
+	}
+}
+
+// enqueue put the message into the send queue without blocking the caller
+// more than the block timeout, the message is dropped by the drop policy if the queue is full.
+func (r *kafkaReporter) enqueue(msg *sarama.ProducerMessage) {
+	select {
+	case r.sendCh <- msg:
+		return
+	default:
+	}
+
+	switch r.dropPolicy {
+	case DropOldest:
+		for {
+			select {
+			case r.sendCh <- msg:
+				return
+			default:
+			}
+			select {
+			case <-r.sendCh:
+				atomic.AddUint64(&r.counter.dropped, 1)
+			default:
+			}
+		}
+	case Block:
+		timer := time.NewTimer(r.blockTimeout)
+		defer timer.Stop()
+		select {
+		case r.sendCh <- msg:
+			return
+		case <-timer.C:
+		case <-r.ctx.Done():
+		}
+	}
+	atomic.AddUint64(&r.counter.dropped, 1)
+}
+
+func (r *kafkaReporter) initSendPipeline() {
+	r.drainCh = make(chan struct{})
+	r.pipelineDone = make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(r.pipelineDone)
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-r.drainCh:
+				// send all the pending segments and logs on closing
+				for {
+					select {
+					case msg := <-r.sendCh:
+						r.produce(msg)
+					case msg := <-r.logCh:
+						r.produce(msg)
+					default:
+						return
+					}
+				}
+			case msg := <-r.sendCh:
+				r.produce(msg)
+			case msg := <-r.logCh:
+				r.produce(msg)
+			}
+		}
+	}()
+}
+
+// produce hands the message over to the kafka producer until the reporter is closing,
+// only the goroutines waited on closing produce, so the producer input is never closed here.
+// Only the segment messages, which carry the meta, are counted.
+func (r *kafkaReporter) produce(msg *sarama.ProducerMessage) bool {
+	meta, isSegment := msg.Metadata.(*messageMeta)
+	select {
+	case r.producer.Input() <- msg:
+		if isSegment {
+			atomic.AddUint64(&r.counter.sent, uint64(meta.segments))
+		}
+		return true
+	case <-r.ctx.Done():
+		if isSegment {
+			atomic.AddUint64(&r.counter.dropped, uint64(meta.segments))
+		}
+		return false
+	}
+}
+
+// messageMeta is kept in sarama.ProducerMessage.Metadata of the segment messages
+type messageMeta struct {
+	// segments is the number of segments in the message
+	segments int
+	// spooledAt is the time the message was spooled first
+	spooledAt time.Time
+}
+
+func metaOf(msg *sarama.ProducerMessage) *messageMeta {
+	if meta, ok := msg.Metadata.(*messageMeta); ok {
+		return meta
+	}
+	return &messageMeta{segments: 1}
+}
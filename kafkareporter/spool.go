@@ -0,0 +1,343 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafkareporter
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	spoolFileName              = "segments.spool"
+	defaultSpoolReplayInterval = 10 * time.Second
+)
+
+const (
+	spoolFieldTopic protowire.Number = iota + 1
+	spoolFieldKey
+	spoolFieldValue
+	spoolFieldTimestamp
+	spoolFieldSegments
+)
+
+var errSpoolCorrupted = errors.New("spool record corrupted")
+
+// spool persists the messages failed to send in a file as length-prefixed
+// protobuf records, and returns them in order for replaying.
+type spool struct {
+	// lastFailure is accessed atomically, keep it at the top for 64-bit alignment
+	lastFailure int64
+	mu          sync.Mutex
+	path        string
+	file        *os.File
+	size        int64
+	maxSize     int64
+	maxAge      time.Duration
+}
+
+func newSpool(dir string, maxSize int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, spoolFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &spool{
+		path:    path,
+		file:    f,
+		size:    info.Size(),
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}, nil
+}
+
+// append persists the message, it's dropped if the spool exceeds the max size.
+func (s *spool) append(msg *sarama.ProducerMessage) error {
+	atomic.StoreInt64(&s.lastFailure, time.Now().UnixNano())
+
+	meta := metaOf(msg)
+	if meta.spooledAt.IsZero() {
+		meta.spooledAt = time.Now()
+	}
+	b, err := encodeSpoolRecord(msg, meta)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size+int64(len(b)) > s.maxSize {
+		return errors.New("spool is full")
+	}
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// failedSince returns whether a message is failed to send in the duration
+func (s *spool) failedSince(d time.Duration) bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastFailure))) < d
+}
+
+// spoolRecord is a message read from the spool, end is the offset after it in the spool file
+type spoolRecord struct {
+	msg     *sarama.ProducerMessage
+	end     int64
+	expired bool
+}
+
+// next reads the record at the offset of the spool without removing it, io.EOF is returned
+// at the end. The records are read one at a time, so a large spool is not loaded in memory.
+// The corrupted tail, eg: written partially on crash, can not be replayed, so errSpoolCorrupted
+// is returned with the end of the spool to skip it.
+func (s *spool) next(offset int64) (spoolRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset >= s.size {
+		return spoolRecord{}, io.EOF
+	}
+
+	prefix := make([]byte, binary.MaxVarintLen64)
+	if int64(len(prefix)) > s.size-offset {
+		prefix = prefix[:s.size-offset]
+	}
+	if _, err := s.file.ReadAt(prefix, offset); err != nil {
+		return spoolRecord{}, err
+	}
+	length, n := protowire.ConsumeVarint(prefix)
+	if n < 0 || length > uint64(s.size-offset-int64(n)) {
+		return spoolRecord{end: s.size}, errSpoolCorrupted
+	}
+	record := make([]byte, length)
+	if _, err := s.file.ReadAt(record, offset+int64(n)); err != nil {
+		return spoolRecord{}, err
+	}
+	msg, err := decodeSpoolRecord(record)
+	if err != nil {
+		return spoolRecord{end: s.size}, err
+	}
+	return spoolRecord{
+		msg:     msg,
+		end:     offset + int64(n) + int64(length),
+		expired: s.maxAge > 0 && time.Since(metaOf(msg).spooledAt) > s.maxAge,
+	}, nil
+}
+
+// discard removes the first n bytes of the spool, the records appended after reading are kept.
+// The spool is truncated if all the records are discarded, otherwise the rest is copied to a
+// new file and renamed to the spool, so nothing is lost on crash.
+func (s *spool) discard(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n >= s.size {
+		if err := s.file.Truncate(0); err != nil {
+			return err
+		}
+		s.size = 0
+		return nil
+	}
+	tmp := s.path + ".tmp"
+	if err := copyFileSync(tmp, io.NewSectionReader(s.file, n, s.size-n)); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = s.file.Close()
+	s.file = f
+	s.size -= n
+	return nil
+}
+
+func (s *spool) close() error {
+	return s.file.Close()
+}
+
+func copyFileSync(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func encodeSpoolRecord(msg *sarama.ProducerMessage, meta *messageMeta) ([]byte, error) {
+	var key, value []byte
+	var err error
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return nil, err
+		}
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, spoolFieldTopic, protowire.BytesType)
+	b = protowire.AppendString(b, msg.Topic)
+	b = protowire.AppendTag(b, spoolFieldKey, protowire.BytesType)
+	b = protowire.AppendBytes(b, key)
+	b = protowire.AppendTag(b, spoolFieldValue, protowire.BytesType)
+	b = protowire.AppendBytes(b, value)
+	b = protowire.AppendTag(b, spoolFieldTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(meta.spooledAt.UnixNano()))
+	b = protowire.AppendTag(b, spoolFieldSegments, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(meta.segments))
+	return protowire.AppendBytes(nil, b), nil
+}
+
+func decodeSpoolRecord(b []byte) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{}
+	meta := &messageMeta{segments: 1}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, errSpoolCorrupted
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return nil, errSpoolCorrupted
+			}
+			switch num {
+			case spoolFieldTimestamp:
+				meta.spooledAt = time.Unix(0, int64(v))
+			case spoolFieldSegments:
+				meta.segments = int(v)
+			}
+			n = m
+		case typ == protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return nil, errSpoolCorrupted
+			}
+			switch num {
+			case spoolFieldTopic:
+				msg.Topic = string(v)
+			case spoolFieldKey:
+				msg.Key = sarama.ByteEncoder(v)
+			case spoolFieldValue:
+				msg.Value = sarama.ByteEncoder(v)
+			}
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, errSpoolCorrupted
+			}
+		}
+		b = b[n:]
+	}
+	msg.Metadata = meta
+	return msg, nil
+}
+
+func (r *kafkaReporter) initSpoolReplay() {
+	if r.spool == nil {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(defaultSpoolReplayInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				// replay after the producer recovers
+				if r.spool.failedSince(defaultSpoolReplayInterval) {
+					continue
+				}
+				r.replaySpool()
+			}
+		}
+	}()
+}
+
+// replaySpool hands the spooled segments over to the kafka producer in order, they are
+// removed from the spool only after handed over, the ones failed again are spooled by the producer errors.
+func (r *kafkaReporter) replaySpool() {
+	handed := int64(0)
+	defer func() {
+		if err := r.spool.discard(handed); err != nil {
+			r.logger.Printf("discard spool error %v", err)
+		}
+	}()
+	for {
+		record, err := r.spool.next(handed)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			r.logger.Printf("read spool error %v", err)
+			handed = record.end
+			return
+		}
+		meta := metaOf(record.msg)
+		if record.expired {
+			atomic.AddUint64(&r.counter.dropped, uint64(meta.segments))
+			handed = record.end
+			continue
+		}
+		select {
+		case r.producer.Input() <- record.msg:
+			atomic.AddUint64(&r.counter.sent, uint64(meta.segments))
+			handed = record.end
+		case <-r.ctx.Done():
+			// the rest are kept for the next boot
+			return
+		}
+	}
+}
@@ -18,10 +18,13 @@ package logrus
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/SkyAPM/go2sky"
 	"github.com/sirupsen/logrus"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
 )
 
 func ExampleWrapFormat() {
@@ -51,3 +54,25 @@ func ExampleWrapFormat() {
 	// {"SW_CTX":"[,,N/A,N/A,-1]","level":"info","msg":"test1"}
 	// level=info msg=test2 SW_CTX="[,,N/A,N/A,-1]"
 }
+
+type printSender struct{}
+
+func (printSender) SendLog(ctx context.Context, logData *logv3.LogData) {
+	fmt.Print(logData.GetBody().GetText().GetText())
+	for _, tag := range logData.GetTags().GetData() {
+		fmt.Printf(" %s=%s", tag.Key, tag.Value)
+	}
+	fmt.Println()
+}
+
+func ExampleReporterHook() {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	// report logging to SkyWalking, eg: by the kafka reporter
+	logger.AddHook(NewReporterHook(printSender{}, logrus.InfoLevel))
+	logger.WithContext(context.Background()).WithField("foo", "bar").Info("test")
+
+	// Output:
+	// test level=info foo=bar
+}
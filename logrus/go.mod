@@ -5,4 +5,5 @@ go 1.12
 require (
 	github.com/SkyAPM/go2sky v1.5.0
 	github.com/sirupsen/logrus v1.8.1
+	skywalking.apache.org/repo/goapi v0.0.0-20220401015832-2c9eee9481eb
 )
@@ -0,0 +1,90 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package logrus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	commonv3 "skywalking.apache.org/repo/goapi/collect/common/v3"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
+)
+
+// LogSender send log data to SkyWalking, eg: the kafka reporter
+type LogSender interface {
+	SendLog(ctx context.Context, logData *logv3.LogData)
+}
+
+// ReporterHook is a logrus hook to report logging to SkyWalking
+type ReporterHook struct {
+	sender LogSender
+	levels []logrus.Level
+}
+
+// NewReporterHook create a hook reporting the logging of levels by sender,
+// all levels are reported if levels is empty
+func NewReporterHook(sender LogSender, levels ...logrus.Level) *ReporterHook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+
+	return &ReporterHook{sender, levels}
+}
+
+// Levels returns the levels to report
+func (hook *ReporterHook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+// Fire report logging with trace context
+func (hook *ReporterHook) Fire(entry *logrus.Entry) error {
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]*commonv3.KeyStringValuePair, 0, len(keys)+1)
+	tags = append(tags, &commonv3.KeyStringValuePair{
+		Key:   "level",
+		Value: entry.Level.String(),
+	})
+	for _, k := range keys {
+		tags = append(tags, &commonv3.KeyStringValuePair{
+			Key:   k,
+			Value: fmt.Sprintf("%v", entry.Data[k]),
+		})
+	}
+
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	hook.sender.SendLog(ctx, &logv3.LogData{
+		Timestamp: entry.Time.UnixNano() / 1e6,
+		Body: &logv3.LogDataBody{
+			Type: "text",
+			Content: &logv3.LogDataBody_Text{
+				Text: &logv3.TextLog{Text: entry.Message},
+			},
+		},
+		Tags: &logv3.LogTags{Data: tags},
+	})
+	return nil
+}
@@ -0,0 +1,170 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+func TestNonContextMethods(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	root, ctx, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("root"))
+	if err != nil {
+		t.Fatalf("create root span error: %v", err)
+	}
+	db := openFakeDB(t, tracer, WithAmbientContext(func() context.Context {
+		return ctx
+	}))
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Errorf("ping error: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Errorf("exec error: %v", err)
+	}
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		t.Errorf("query error: %v", err)
+	} else {
+		rows.Close()
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM users").Scan(&id); err != nil {
+		t.Errorf("query row error: %v", err)
+	}
+	stmt, err := db.Prepare("SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("prepare error: %v", err)
+	}
+	if _, err := stmt.Exec(); err != nil {
+		t.Errorf("stmt exec error: %v", err)
+	}
+	if rows, err := stmt.Query(); err != nil {
+		t.Errorf("stmt query error: %v", err)
+	} else {
+		rows.Close()
+	}
+	if err := stmt.QueryRow().Scan(&id); err != nil {
+		t.Errorf("stmt query row error: %v", err)
+	}
+	stmt.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("commit error: %v", err)
+	}
+	root.End()
+
+	spans := <-r.segments
+	var names []string
+	for _, s := range sortSpans(spans[:len(spans)-1]) {
+		names = append(names, s.OperationName())
+	}
+	want := []string{"ping", "execute", "query", "query", "execute", "query", "query", "begin", "commit"}
+	if len(names) != len(want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != "Mysql/Go2Sky/"+want[i] {
+			t.Errorf("span %d = %s, want %s", i, names[i], want[i])
+		}
+	}
+}
+
+func TestNonContextTracedQuery(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	var ctx context.Context
+	db := openFakeDB(t, tracer, WithAmbientContext(func() context.Context {
+		return ctx
+	}))
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(c context.Context) {
+		ctx = c
+		rows, err := db.TracedQuery("SELECT id FROM users")
+		if err != nil {
+			t.Fatalf("query error: %v", err)
+		}
+		// drain the rows to end the span
+		for rows.Next() {
+		}
+		stmt, err := db.Prepare("SELECT id FROM users")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		defer stmt.Close()
+		rows, err = stmt.TracedQuery()
+		if err != nil {
+			t.Fatalf("stmt query error: %v", err)
+		}
+		for rows.Next() {
+		}
+	})
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	for _, s := range spans {
+		if s.OperationName() != "Mysql/Go2Sky/query" {
+			t.Errorf("span = %s, want query", s.OperationName())
+		}
+	}
+}
+
+func TestNonContextMethodsWithoutAmbientContext(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "no ambient context"},
+		{name: "nil ambient context", opts: []Option{WithAmbientContext(func() context.Context {
+			return nil
+		})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, tt.opts...)
+			defer db.Close()
+
+			if _, err := db.Exec("DELETE FROM users"); err != nil {
+				t.Fatalf("exec error: %v", err)
+			}
+			// the span starts a new segment of context.Background()
+			select {
+			case spans := <-r.segments:
+				if len(spans) != 1 {
+					t.Fatalf("want 1 span, got %d", len(spans))
+				}
+				if spans[0].OperationName() != "Mysql/Go2Sky/execute" {
+					t.Errorf("span = %s, want execute", spans[0].OperationName())
+				}
+				if spans[0].Context().ParentSpanID != -1 {
+					t.Errorf("parent span id = %d, want -1", spans[0].Context().ParentSpanID)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("segment is not reported")
+			}
+		})
+	}
+}
@@ -18,60 +18,143 @@ package sql
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
-	"regexp"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SkyAPM/go2sky"
 	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
 )
 
+// component id defined in the component-libraries.yml of SkyWalking
 const (
-	componentIDUnknown = 0
-	componentIDMysql   = 5012
+	componentIDUnknown    = 0
+	componentIDOracle     = 6
+	componentIDPostgresql = 22
+	componentIDSqlite     = 31
+	componentIDMssql      = 104
+	componentIDClickhouse = 120
+	componentIDMysql      = 5012
+)
+
+const (
+	tagDBRowsAffected go2sky.Tag = "db.rows_affected"
+	tagDBLastInsertID go2sky.Tag = "db.last_insert_id"
+	tagDBRowsScanned  go2sky.Tag = "db.rows_scanned"
+	tagDBSlowQuery    go2sky.Tag = "slow_query"
+	tagDBTxOutcome    go2sky.Tag = "db.transaction.outcome"
 )
 
 // ErrUnsupportedOp operation unsupported by the underlying driver
 var ErrUnsupportedOp = errors.New("operation unsupported by the underlying driver")
 
-func argsToString(args []interface{}) string {
+func argsToString(args []interface{}, redactor ParamRedactor) string {
 	sb := strings.Builder{}
-	for _, arg := range args {
+	for i, arg := range args {
+		var name string
+		if named, ok := arg.(sql.NamedArg); ok {
+			name, arg = named.Name, named.Value
+		}
+		if redactor != nil {
+			sb.WriteString(redactor(i+1, name, arg))
+			sb.WriteString(", ")
+			continue
+		}
 		sb.WriteString(fmt.Sprintf("%v, ", arg))
 	}
 	return sb.String()
 }
 
-func createSpan(ctx context.Context, tracer *go2sky.Tracer, opts *options, operation string) (go2sky.Span, error) {
+// tagQuery tag the sanitized statement and redacted parameters if enabled
+func tagQuery(span go2sky.Span, opts *options, query string, args []interface{}) {
+	if opts.reportQuery {
+		span.Tag(go2sky.TagDBStatement, opts.statement(query))
+	}
+	if opts.reportParam {
+		span.Tag(go2sky.TagDBSqlParameters, truncate(argsToString(args, opts.paramRedactor), opts.maxTagLength))
+	}
+}
+
+// tagSlowQuery tag the statement and the duration if the execution since start
+// exceeds the slow query threshold, even if the query report is disabled
+func tagSlowQuery(ctx context.Context, span go2sky.Span, opts *options, query string, start time.Time) {
+	if opts.slowQueryThreshold <= 0 {
+		return
+	}
+	duration := time.Since(start)
+	if duration < opts.slowQueryThreshold {
+		return
+	}
+	statement := opts.statement(query)
+	if !opts.reportQuery {
+		span.Tag(go2sky.TagDBStatement, statement)
+	}
+	span.Tag(tagDBSlowQuery, duration.String())
+	if opts.slowQueryCallback != nil {
+		opts.slowQueryCallback(ctx, statement, duration)
+	}
+}
+
+func createSpan(ctx context.Context, tracer *go2sky.Tracer, opts *options, operation string) go2sky.Span {
+	return createQuerySpan(ctx, tracer, opts, operation, "")
+}
+
+// createQuerySpan create the exit span of the operation, a noop span is returned
+// if the span can not be created, tracing never breaks the query
+func createQuerySpan(ctx context.Context, tracer *go2sky.Tracer, opts *options, operation string, query string) go2sky.Span {
+	if tracer == nil || ctx == nil {
+		return &go2sky.NoopSpan{}
+	}
 	s, _, err := tracer.CreateLocalSpan(ctx,
 		go2sky.WithSpanType(go2sky.SpanTypeExit),
-		go2sky.WithOperationName(opts.getOpName(operation)),
+		go2sky.WithOperationName(opts.getOpName(operation, query)),
 	)
 	if err != nil {
-		return nil, err
+		return &go2sky.NoopSpan{}
 	}
 	s.SetPeer(opts.peer)
 	s.SetComponent(opts.componentID)
 	s.SetSpanLayer(agentv3.SpanLayer_Database)
 	s.Tag(go2sky.TagDBType, string(opts.dbType))
 	s.Tag(go2sky.TagDBInstance, opts.peer)
-	return s, nil
+	return s
+}
+
+// createQuerySpanAt create the exit span of the operation started at start, it is used
+// when the span is created after the operation, e.g. the driver may skip it by driver.ErrSkip
+func createQuerySpanAt(ctx context.Context, tracer *go2sky.Tracer, opts *options, operation string, query string, start time.Time) go2sky.Span {
+	span := createQuerySpan(ctx, tracer, opts, operation, query)
+	setStartTime(span, start)
+	return span
 }
 
-// parseDsn parse dsn to a endpoint addr string (host:port)
-func parseDsn(dbType DBType, dsn string) string {
-	var addr string
-	switch dbType {
-	case MYSQL:
-		// [user[:password]@][net[(addr)]]/dbname[?param1=value1&paramN=valueN]
-		re := regexp.MustCompile(`\(.+\)`)
-		addr = re.FindString(dsn)
-		addr = addr[1 : len(addr)-1]
-	case IPV4:
-		// ipv4 addr
-		re := regexp.MustCompile(`((2(5[0-5]|[0-4]\d))|[0-1]?\d{1,2})(\.((2(5[0-5]|[0-4]\d))|[0-1]?\d{1,2})){3}:\d{1,5}`)
-		addr = re.FindString(dsn)
+// setStartTime set the start time of the span, go2sky has no option of the start time,
+// so it is set to the StartTime field by reflection and kept if the field is not found
+func setStartTime(span go2sky.Span, start time.Time) {
+	v := reflect.ValueOf(span)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	f := v.Elem().FieldByName("StartTime")
+	if f.IsValid() && f.CanSet() && f.Type() == reflect.TypeOf(start) {
+		f.Set(reflect.ValueOf(start))
+	}
+}
+
+// tagResult tag the rows affected and last insert id if supported by the driver
+func tagResult(span go2sky.Span, opts *options, res driver.Result) {
+	if !opts.reportResult || res == nil {
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		span.Tag(tagDBRowsAffected, strconv.FormatInt(n, 10))
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		span.Tag(tagDBLastInsertID, strconv.FormatInt(id, 10))
 	}
-	return addr
 }
@@ -0,0 +1,122 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+func TestSlowQuery(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	var slow []string
+	db := openFakeDB(t, tracer, WithSlowQueryThreshold(time.Nanosecond, func(ctx context.Context, statement string, duration time.Duration) {
+		if go2sky.SpanID(ctx) == go2sky.EmptySpanID {
+			t.Error("callback ctx should contain the span")
+		}
+		slow = append(slow, statement)
+	}))
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM users"); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+	})
+	span := checkSpan(t, spans, "Mysql/Go2Sky/execute", false, map[string]string{"db.statement": "DELETE FROM users"})
+	if _, ok := spanTags(span)["slow_query"]; !ok {
+		t.Error("slow_query tag is not reported")
+	}
+	if len(slow) != 1 || slow[0] != "DELETE FROM users" {
+		t.Errorf("slow queries = %v", slow)
+	}
+
+	db = openFakeDB(t, tracer, WithSlowQueryThreshold(time.Hour))
+	defer db.Close()
+	spans = trace(t, tracer, r, func(ctx context.Context) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM users"); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+	})
+	span = checkSpan(t, spans, "Mysql/Go2Sky/execute", false, nil)
+	if tags := spanTags(span); tags["slow_query"] != "" || tags["db.statement"] != "" {
+		t.Errorf("fast query should not be tagged, got %v", tags)
+	}
+}
+
+func TestSlowQueryOfStatements(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	var slow []string
+	db := openFakeDB(t, tracer,
+		WithQuerySanitizer(ObfuscateLiterals),
+		WithSlowQueryThreshold(time.Nanosecond, func(ctx context.Context, statement string, duration time.Duration) {
+			slow = append(slow, statement)
+		}),
+	)
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		stmt, err := db.PrepareContext(ctx, "DELETE FROM users WHERE id = 1")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		defer stmt.Close()
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			t.Errorf("stmt exec error: %v", err)
+		}
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("conn error: %v", err)
+		}
+		defer conn.Close()
+		var id int
+		if err := conn.QueryRowContext(ctx, "SELECT id FROM users WHERE name = 'foo'").Scan(&id); err != nil {
+			t.Errorf("conn query row error: %v", err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET age = 2"); err != nil {
+			t.Errorf("tx exec error: %v", err)
+		}
+	})
+
+	// the sanitized statement is reported
+	want := []string{"DELETE FROM users WHERE id = ?", "SELECT id FROM users WHERE name = ?", "UPDATE users SET age = ?"}
+	if len(slow) != len(want) {
+		t.Fatalf("slow queries = %v, want %v", slow, want)
+	}
+	for i, statement := range want {
+		if slow[i] != statement {
+			t.Errorf("slow query = %s, want %s", slow[i], statement)
+		}
+	}
+	for _, s := range spans {
+		tags := spanTags(s)
+		if _, ok := tags["db.statement"]; !ok {
+			continue
+		}
+		if _, ok := tags["slow_query"]; !ok {
+			t.Errorf("slow_query tag is not reported of %s", tags["db.statement"])
+		}
+	}
+}
@@ -33,12 +33,9 @@ type Conn struct {
 
 // PingContext support trace
 func (c *Conn) PingContext(ctx context.Context) error {
-	span, err := createSpan(ctx, c.db.tracer, c.db.opts, "ping")
-	if err != nil {
-		return err
-	}
+	span := createSpan(ctx, c.db.tracer, c.db.opts, "ping")
 	defer span.End()
-	err = c.Conn.PingContext(ctx)
+	err := c.Conn.PingContext(ctx)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
 	}
@@ -47,64 +44,71 @@ func (c *Conn) PingContext(ctx context.Context) error {
 
 // ExecContext support trace
 func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	span, err := createSpan(ctx, c.db.tracer, c.db.opts, "execute")
-	if err != nil {
-		return nil, err
-	}
+	span := createQuerySpan(ctx, c.db.tracer, c.db.opts, "execute", query)
 	defer span.End()
 
-	if c.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if c.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, c.db.opts, query, args)
 
+	start := time.Now()
 	res, err := c.Conn.ExecContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, c.db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		return res, err
 	}
-	return res, err
+	tagResult(span, c.db.opts, res)
+	return res, nil
 }
 
 // QueryContext support trace
 func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	span, err := createSpan(ctx, c.db.tracer, c.db.opts, "query")
+	rows, span, err := c.queryWithSpan(ctx, query, args)
 	if err != nil {
 		return nil, err
 	}
-	defer span.End()
+	span.End()
+	return rows, nil
+}
 
-	if c.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if c.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
+// TracedQueryContext support trace like QueryContext, but the span of query ends when the
+// returned rows are closed or iterated to the end, the number of rows scanned and the
+// error of iteration are reported
+func (c *Conn) TracedQueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, span, err := c.queryWithSpan(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
+	return newRows(rows, span), nil
+}
+
+// queryWithSpan returns the rows with the span of query, the span is ended on error
+func (c *Conn) queryWithSpan(ctx context.Context, query string, args []interface{}) (*sql.Rows, go2sky.Span, error) {
+	span := createQuerySpan(ctx, c.db.tracer, c.db.opts, "query", query)
+
+	tagQuery(span, c.db.opts, query, args)
 
+	start := time.Now()
 	rows, err := c.Conn.QueryContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, c.db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, nil, err
 	}
-	return rows, err
+	return rows, span, nil
 }
 
 // QueryRowContext support trace
 func (c *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	span, err := createSpan(ctx, c.db.tracer, c.db.opts, "query")
-	if err != nil {
-		return nil
-	}
+	span := createQuerySpan(ctx, c.db.tracer, c.db.opts, "query", query)
 	defer span.End()
 
-	if c.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if c.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, c.db.opts, query, args)
 
-	return c.Conn.QueryRowContext(ctx, query, args...)
+	start := time.Now()
+	row := c.Conn.QueryRowContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, c.db.opts, query, start)
+	return row
 }
 
 // PrepareContext support trace
@@ -119,21 +123,7 @@ func (c *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error)
 
 // BeginTx support trace
 func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
-	span, err := createSpan(ctx, c.db.tracer, c.db.opts, "begin")
-	if err != nil {
-		return nil, err
-	}
-	defer span.End()
-
-	tx, err := c.Conn.BeginTx(ctx, opts)
-	if err != nil {
-		span.Error(time.Now(), err.Error())
-		return nil, err
-	}
-
-	return &Tx{
-		Tx:  tx,
-		db:  c.db,
-		ctx: ctx,
-	}, nil
+	return beginTx(ctx, c.db, func(ctx context.Context) (*sql.Tx, error) {
+		return c.Conn.BeginTx(ctx, opts)
+	})
 }
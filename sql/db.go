@@ -31,6 +31,7 @@ type DB struct {
 
 	tracer *go2sky.Tracer
 	opts   *options
+	stats  *statsCollector
 }
 
 // OpenDB support trace
@@ -51,6 +52,7 @@ func OpenDB(c driver.Connector, tracer *go2sky.Tracer, opts ...Option) *DB {
 		DB:     db,
 		tracer: tracer,
 		opts:   options,
+		stats:  startStatsCollector(db, options),
 	}
 }
 
@@ -72,30 +74,44 @@ func Open(driverName, dataSourceName string, tracer *go2sky.Tracer, opts ...Opti
 	}
 
 	if options.peer == "" {
-		options.peer = parseDsn(options.dbType, dataSourceName)
+		options.peer = ParseDsn(options.dbType, dataSourceName)
 	}
 
 	return &DB{
 		DB:     db,
 		tracer: tracer,
 		opts:   options,
+		stats:  startStatsCollector(db, options),
 	}, nil
 }
 
+// Close stop the stats collector and close the database
+func (db *DB) Close() error {
+	db.stats.stop()
+	return db.DB.Close()
+}
+
+// Ping support trace
+func (db *DB) Ping() error {
+	return db.PingContext(db.opts.context())
+}
+
 // PingContext support trace
 func (db *DB) PingContext(ctx context.Context) error {
-	span, err := createSpan(ctx, db.tracer, db.opts, "ping")
-	if err != nil {
-		return err
-	}
+	span := createSpan(ctx, db.tracer, db.opts, "ping")
 	defer span.End()
-	err = db.DB.PingContext(ctx)
+	err := db.DB.PingContext(ctx)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
 	}
 	return err
 }
 
+// Prepare support trace
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	return db.PrepareContext(db.opts.context(), query)
+}
+
 // PrepareContext support trace
 func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	stmt, err := db.DB.PrepareContext(ctx, query)
@@ -109,87 +125,105 @@ func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	}, nil
 }
 
+// Exec support trace
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(db.opts.context(), query, args...)
+}
+
 // ExecContext support trace
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	span, err := createSpan(ctx, db.tracer, db.opts, "execute")
-	if err != nil {
-		return nil, err
-	}
+	span := createQuerySpan(ctx, db.tracer, db.opts, "execute", query)
 	defer span.End()
 
-	if db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, db.opts, query, args)
 
+	start := time.Now()
 	res, err := db.DB.ExecContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		return res, err
 	}
-	return res, err
+	tagResult(span, db.opts, res)
+	return res, nil
+}
+
+// Query support trace
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(db.opts.context(), query, args...)
 }
 
 // QueryContext support trace
 func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	span, err := createSpan(ctx, db.tracer, db.opts, "query")
+	rows, span, err := db.queryWithSpan(ctx, query, args)
 	if err != nil {
 		return nil, err
 	}
-	defer span.End()
+	span.End()
+	return rows, nil
+}
 
-	if db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
+// TracedQuery support trace, refer to TracedQueryContext
+func (db *DB) TracedQuery(query string, args ...interface{}) (*Rows, error) {
+	return db.TracedQueryContext(db.opts.context(), query, args...)
+}
+
+// TracedQueryContext support trace like QueryContext, but the span of query ends when the
+// returned rows are closed or iterated to the end, the number of rows scanned and the
+// error of iteration are reported
+func (db *DB) TracedQueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, span, err := db.queryWithSpan(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
+	return newRows(rows, span), nil
+}
+
+// queryWithSpan returns the rows with the span of query, the span is ended on error
+func (db *DB) queryWithSpan(ctx context.Context, query string, args []interface{}) (*sql.Rows, go2sky.Span, error) {
+	span := createQuerySpan(ctx, db.tracer, db.opts, "query", query)
 
+	tagQuery(span, db.opts, query, args)
+
+	start := time.Now()
 	rows, err := db.DB.QueryContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, nil, err
 	}
-	return rows, err
+	return rows, span, nil
+}
+
+// QueryRow support trace
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(db.opts.context(), query, args...)
 }
 
 // QueryRowContext support trace
 func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	span, err := createSpan(ctx, db.tracer, db.opts, "query")
-	if err != nil {
-		return nil
-	}
+	span := createQuerySpan(ctx, db.tracer, db.opts, "query", query)
 	defer span.End()
 
-	if db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, db.opts, query, args)
+
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, db.opts, query, start)
+	return row
+}
 
-	return db.DB.QueryRowContext(ctx, query, args...)
+// Begin support trace
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(db.opts.context(), nil)
 }
 
 // BeginTx support trace
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
-	span, err := createSpan(ctx, db.tracer, db.opts, "begin")
-	if err != nil {
-		return nil, err
-	}
-	defer span.End()
-
-	tx, err := db.DB.BeginTx(ctx, opts)
-	if err != nil {
-		span.Error(time.Now(), err.Error())
-		return nil, err
-	}
-
-	return &Tx{
-		Tx:  tx,
-		db:  db,
-		ctx: ctx,
-	}, nil
+	return beginTx(ctx, db, func(ctx context.Context) (*sql.Tx, error) {
+		return db.DB.BeginTx(ctx, opts)
+	})
 }
 
 // Conn support trace
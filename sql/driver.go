@@ -0,0 +1,563 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+// Register register a traced driver wrapping d with the name to database/sql,
+// every access path of the *sql.DB opened by sql.Open(name, dsn) is traced,
+// including the libraries accepting a plain *sql.DB. Like sql.Register,
+// it panics if called twice with the same name
+func Register(name string, d driver.Driver, tracer *go2sky.Tracer, opts ...Option) {
+	sql.Register(name, wrapDriver(d, tracer, opts...))
+}
+
+// WrapConnector wrap the driver.Connector and support trace, use it with sql.OpenDB,
+// the dsn is unknown to the connector, so use WithPeerAddr to set the peer address
+func WrapConnector(c driver.Connector, tracer *go2sky.Tracer, opts ...Option) driver.Connector {
+	return &tracedConnector{
+		Connector: c,
+		driver:    wrapDriver(c.Driver(), tracer, opts...),
+		opts:      newOptions(opts...),
+	}
+}
+
+func wrapDriver(d driver.Driver, tracer *go2sky.Tracer, opts ...Option) *tracedDriver {
+	return &tracedDriver{
+		Driver: d,
+		tracer: tracer,
+		opts:   newOptions(opts...),
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	options := &options{
+		dbType:      UNKNOWN,
+		componentID: componentIDUnknown,
+		reportQuery: false,
+		reportParam: false,
+	}
+	for _, o := range opts {
+		o(options)
+	}
+	return options
+}
+
+// dsnOptions return the options with the peer parsed from dsn
+func (o *options) dsnOptions(dsn string) *options {
+	if o.peer != "" {
+		return o
+	}
+	options := *o
+	options.peer = ParseDsn(options.dbType, dsn)
+	return &options
+}
+
+// tracedDriver wrap driver.Driver and support trace
+type tracedDriver struct {
+	driver.Driver
+
+	tracer *go2sky.Tracer
+	opts   *options
+}
+
+// Open support trace
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(conn, d.tracer, d.opts.dsnOptions(name)), nil
+}
+
+// OpenConnector support trace
+func (d *tracedDriver) OpenConnector(name string) (driver.Connector, error) {
+	var connector driver.Connector = dsnConnector{dsn: name, driver: d.Driver}
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		var err error
+		if connector, err = dc.OpenConnector(name); err != nil {
+			return nil, err
+		}
+	}
+	return &tracedConnector{
+		Connector: connector,
+		driver:    d,
+		opts:      d.opts.dsnOptions(name),
+	}, nil
+}
+
+// dsnConnector is the connector of the driver not implementing driver.DriverContext
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// tracedConnector wrap driver.Connector and support trace
+type tracedConnector struct {
+	driver.Connector
+
+	driver *tracedDriver
+	opts   *options
+}
+
+// Connect support trace
+func (c *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(conn, c.driver.tracer, c.opts), nil
+}
+
+// Driver return the traced driver
+func (c *tracedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close close the underlying connector if it implements io.Closer
+func (c *tracedConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// tracedConn wrap driver.Conn and support trace
+type tracedConn struct {
+	driver.Conn
+
+	tracer *go2sky.Tracer
+	opts   *options
+}
+
+func wrapConn(conn driver.Conn, tracer *go2sky.Tracer, opts *options) *tracedConn {
+	return &tracedConn{
+		Conn:   conn,
+		tracer: tracer,
+		opts:   opts,
+	}
+}
+
+// Ping support trace
+func (c *tracedConn) Ping(ctx context.Context) error {
+	pinger, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	span := createSpan(ctx, c.tracer, c.opts, "ping")
+	defer span.End()
+	err := pinger.Ping(ctx)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	return err
+}
+
+// ExecContext support trace
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql falls back to prepare and execute the statement
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := execer.ExecContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		// database/sql prepares and executes the statement, which is traced by tracedStmt
+		return nil, err
+	}
+	span := createQuerySpanAt(ctx, c.tracer, c.opts, "execute", query, start)
+	defer span.End()
+
+	tagQuery(span, c.opts, query, namedValueArgs(args))
+	tagSlowQuery(ctx, span, c.opts, query, start)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+		return nil, err
+	}
+	tagResult(span, c.opts, res)
+	return res, nil
+}
+
+// QueryContext support trace
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		// database/sql falls back to prepare and query the statement
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		// database/sql prepares and queries the statement, which is traced by tracedStmt
+		return nil, err
+	}
+	span := createQuerySpanAt(ctx, c.tracer, c.opts, "query", query, start)
+
+	tagQuery(span, c.opts, query, namedValueArgs(args))
+	tagSlowQuery(ctx, span, c.opts, query, start)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, err
+	}
+	return wrapDriverRows(rows, span, c.opts), nil
+}
+
+// Prepare support trace
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext support trace
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{
+		Stmt:  stmt,
+		conn:  c,
+		query: query,
+	}, nil
+}
+
+// Begin support trace
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx support trace
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	span := createSpan(ctx, c.tracer, c.opts, "begin")
+	defer span.End()
+
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		err = ErrUnsupportedOp
+	} else {
+		tx, err = c.Conn.Begin() // nolint
+	}
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+		return nil, err
+	}
+	return &tracedTx{
+		Tx:   tx,
+		conn: c,
+		ctx:  ctx,
+	}, nil
+}
+
+// ResetSession reset the underlying conn if it implements driver.SessionResetter
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid check the underlying conn if it implements driver.Validator
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue check the value by the underlying conn if it implements driver.NamedValueChecker
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	// database/sql falls back to the default converter
+	return driver.ErrSkip
+}
+
+// tracedTx wrap driver.Tx and support trace
+type tracedTx struct {
+	driver.Tx
+
+	conn *tracedConn
+	ctx  context.Context
+}
+
+// Commit support trace
+func (tx *tracedTx) Commit() error {
+	span := createSpan(tx.ctx, tx.conn.tracer, tx.conn.opts, "commit")
+	defer span.End()
+
+	err := tx.Tx.Commit()
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	return err
+}
+
+// Rollback support trace
+func (tx *tracedTx) Rollback() error {
+	span := createSpan(tx.ctx, tx.conn.tracer, tx.conn.opts, "rollback")
+	defer span.End()
+
+	err := tx.Tx.Rollback()
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	return err
+}
+
+// tracedStmt wrap driver.Stmt and support trace
+type tracedStmt struct {
+	driver.Stmt
+
+	conn  *tracedConn
+	query string
+}
+
+// Exec support trace
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueNamedArgs(args))
+}
+
+// ExecContext support trace
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	span := createQuerySpan(ctx, s.conn.tracer, s.conn.opts, "execute", s.query)
+	defer span.End()
+
+	tagQuery(span, s.conn.opts, s.query, namedValueArgs(args))
+
+	start := time.Now()
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = execer.ExecContext(ctx, args)
+	} else if err = ctx.Err(); err == nil {
+		var values []driver.Value
+		if values, err = namedValueValues(args); err == nil {
+			res, err = s.Stmt.Exec(values) // nolint
+		}
+	}
+	tagSlowQuery(ctx, span, s.conn.opts, s.query, start)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+		return nil, err
+	}
+	tagResult(span, s.conn.opts, res)
+	return res, nil
+}
+
+// Query support trace
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valueNamedArgs(args))
+}
+
+// QueryContext support trace
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	span := createQuerySpan(ctx, s.conn.tracer, s.conn.opts, "query", s.query)
+
+	tagQuery(span, s.conn.opts, s.query, namedValueArgs(args))
+
+	start := time.Now()
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else if err = ctx.Err(); err == nil {
+		var values []driver.Value
+		if values, err = namedValueValues(args); err == nil {
+			rows, err = s.Stmt.Query(values) // nolint
+		}
+	}
+	tagSlowQuery(ctx, span, s.conn.opts, s.query, start)
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, err
+	}
+	return wrapDriverRows(rows, span, s.conn.opts), nil
+}
+
+// CheckNamedValue check the value by the underlying stmt if it implements driver.NamedValueChecker
+func (s *tracedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// ColumnConverter return the converter of the underlying stmt if it implements driver.ColumnConverter
+func (s *tracedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if converter, ok := s.Stmt.(driver.ColumnConverter); ok { // nolint
+		return converter.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// tracedRows wrap driver.Rows, the optional interfaces are delegated to the
+// underlying rows with the same defaults as database/sql. If WithRowsTrace
+// is set, the span of query ends when the rows are closed
+type tracedRows struct {
+	driver.Rows
+
+	span    go2sky.Span
+	scanned int64
+	err     error
+}
+
+func wrapDriverRows(rows driver.Rows, span go2sky.Span, opts *options) *tracedRows {
+	if !opts.traceRows {
+		span.End()
+		return &tracedRows{Rows: rows}
+	}
+	return &tracedRows{
+		Rows: rows,
+		span: span,
+	}
+}
+
+// Next support trace
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch err {
+	case nil:
+		r.scanned++
+	case io.EOF:
+	default:
+		r.err = err
+	}
+	return err
+}
+
+// Close support trace
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	if r.span == nil {
+		return err
+	}
+	r.span.Tag(tagDBRowsScanned, strconv.FormatInt(r.scanned, 10))
+	if r.err != nil {
+		r.span.Error(time.Now(), r.err.Error())
+	}
+	r.span.End()
+	r.span = nil
+	return err
+}
+
+// HasNextResultSet delegate to driver.RowsNextResultSet
+func (r *tracedRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet delegate to driver.RowsNextResultSet
+func (r *tracedRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType delegate to driver.RowsColumnTypeScanType
+func (r *tracedRows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName delegate to driver.RowsColumnTypeDatabaseTypeName
+func (r *tracedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength delegate to driver.RowsColumnTypeLength
+func (r *tracedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable delegate to driver.RowsColumnTypeNullable
+func (r *tracedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale delegate to driver.RowsColumnTypePrecisionScale
+func (r *tracedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func namedValueArgs(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+		if arg.Name != "" {
+			values[i] = sql.Named(arg.Name, arg.Value)
+		}
+	}
+	return values
+}
+
+func namedValueValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, ErrUnsupportedOp
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+func valueNamedArgs(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
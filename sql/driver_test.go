@@ -0,0 +1,406 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+var registered int32
+
+// registerTraced register the traced driver wrapping d with a unique name
+func registerTraced(d driver.Driver, tracer *go2sky.Tracer, opts ...Option) string {
+	name := fmt.Sprintf("go2sky-traced-%d", atomic.AddInt32(&registered, 1))
+	Register(name, d, tracer, opts...)
+	return name
+}
+
+func TestRegister(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	name := registerTraced(&fakeDriver{}, tracer, WithSQLDBType(MYSQL), WithQueryReport(), WithParamReport(),
+		WithResultReport(), WithRowsTrace())
+	db, err := sql.Open(name, fakeDsn)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name          string
+		fn            func(ctx context.Context) error
+		operationName string
+		isError       bool
+		tags          map[string]string
+	}{
+		{
+			name:          "ping",
+			fn:            db.PingContext,
+			operationName: "Mysql/Go2Sky/ping",
+		},
+		{
+			name: "exec",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ? AND name = ?", 1, sql.Named("name", "foo"))
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			tags: map[string]string{
+				"db.statement":      "DELETE FROM users WHERE id = ? AND name = ?",
+				"db.sql.parameters": "1, foo, ",
+				"db.rows_affected":  "2",
+				"db.last_insert_id": "7",
+			},
+		},
+		{
+			name: "exec error",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "exec error")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			isError:       true,
+		},
+		{
+			name: "query",
+			fn: func(ctx context.Context) error {
+				rows, err := db.QueryContext(ctx, "SELECT id FROM users")
+				if err != nil {
+					return err
+				}
+				for rows.Next() {
+				}
+				return rows.Close()
+			},
+			operationName: "Mysql/Go2Sky/query",
+			tags: map[string]string{
+				"db.statement":    "SELECT id FROM users",
+				"db.rows_scanned": "3",
+			},
+		},
+		{
+			name: "query error",
+			fn: func(ctx context.Context) error {
+				_, err := db.QueryContext(ctx, "query error")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/query",
+			isError:       true,
+		},
+		{
+			name: "stmt exec",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "UPDATE users SET age = ?")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(ctx, 10)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			tags: map[string]string{
+				"db.statement":      "UPDATE users SET age = ?",
+				"db.sql.parameters": "10, ",
+			},
+		},
+		{
+			name: "stmt query",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "SELECT id FROM users")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				var id int
+				return stmt.QueryRowContext(ctx).Scan(&id)
+			},
+			operationName: "Mysql/Go2Sky/query",
+			tags: map[string]string{
+				"db.rows_scanned": "1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				err = tt.fn(ctx)
+			})
+			if (err != nil) != tt.isError {
+				t.Errorf("error = %v, want error %v", err, tt.isError)
+			}
+			span := checkSpan(t, spans, tt.operationName, tt.isError, tt.tags)
+			if span.Peer() != "127.0.0.1:3306" {
+				t.Errorf("peer = %s, want 127.0.0.1:3306", span.Peer())
+			}
+		})
+	}
+}
+
+func TestRegisterRows(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	name := registerTraced(&fakeDriver{}, tracer, WithSQLDBType(MYSQL), WithRowsTrace())
+	db, err := sql.Open(name, fakeDsn)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name    string
+		query   string
+		scanned string
+		isError bool
+	}{
+		// the rows are closed by database/sql after iterated to the end
+		{name: "rows", query: "SELECT id FROM users", scanned: "3"},
+		{name: "rows error", query: "rows error", scanned: "1", isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				rows, err := db.QueryContext(ctx, tt.query)
+				if err != nil {
+					t.Fatalf("query error: %v", err)
+				}
+				// the optional interfaces of the driver rows are delegated
+				types, err := rows.ColumnTypes()
+				if err != nil || len(types) != 1 || types[0].DatabaseTypeName() != "BIGINT" {
+					t.Errorf("column types = %v, %v", types, err)
+				}
+				for rows.Next() {
+				}
+				if (rows.Err() != nil) != tt.isError {
+					t.Errorf("rows error = %v, want error %v", rows.Err(), tt.isError)
+				}
+			})
+			checkSpan(t, spans, "Mysql/Go2Sky/query", tt.isError, map[string]string{"db.rows_scanned": tt.scanned})
+		})
+	}
+}
+
+func TestRegisterTx(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	name := registerTraced(&fakeDriver{}, tracer, WithSQLDBType(MYSQL))
+	db, err := sql.Open(name, fakeDsn)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, "fail commit"); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+		if err := tx.Commit(); err != errFake {
+			t.Errorf("commit error = %v, want %v", err, errFake)
+		}
+
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Errorf("rollback error: %v", err)
+		}
+
+		if _, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != errFake {
+			t.Errorf("begin error = %v, want %v", err, errFake)
+		}
+	})
+
+	var names []string
+	for _, s := range spans {
+		name := strings.TrimPrefix(s.OperationName(), "Mysql/Go2Sky/")
+		if s.IsError() {
+			name += "!"
+		}
+		names = append(names, name)
+	}
+	want := "begin,execute,commit!,begin,rollback,begin!"
+	if strings.Join(names, ",") != want {
+		t.Errorf("spans = %v, want %s", names, want)
+	}
+}
+
+func TestRegisterLegacyDriver(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	name := registerTraced(&legacyDriver{}, tracer, WithSQLDBType(MYSQL))
+	db, err := sql.Open(name, fakeDsn)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if err := db.PingContext(ctx); err != nil {
+			t.Errorf("ping error: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", 1); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+		var id int
+		if err := db.QueryRowContext(ctx, "SELECT id FROM users").Scan(&id); err != nil {
+			t.Errorf("query row error: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, "prepare error"); err != errFake {
+			t.Errorf("exec error = %v, want %v", err, errFake)
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = :id", sql.Named("id", 1)); err == nil {
+			t.Error("named args are not supported by the legacy driver")
+		}
+	})
+
+	// the legacy conn is not a pinger, exec and query fall back to prepared statements
+	var names []string
+	for _, s := range spans {
+		names = append(names, strings.TrimPrefix(s.OperationName(), "Mysql/Go2Sky/"))
+	}
+	want := "execute,query,execute"
+	if strings.Join(names, ",") != want {
+		t.Errorf("spans = %v, want %s", names, want)
+	}
+}
+
+func TestWrapConnector(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	connector := WrapConnector(&fakeConnector{dsn: fakeDsn, driver: &fakeDriver{}}, tracer,
+		WithSQLDBType(MYSQL), WithPeerAddr("mysql:3306"))
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if _, ok := connector.Driver().(*tracedDriver); !ok {
+		t.Errorf("driver of connector = %T, want traced driver", connector.Driver())
+	}
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM users"); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+	})
+	span := checkSpan(t, spans, "Mysql/Go2Sky/execute", false, nil)
+	if span.Peer() != "mysql:3306" {
+		t.Errorf("peer = %s, want mysql:3306", span.Peer())
+	}
+}
+
+func TestOpenConnectorError(t *testing.T) {
+	tracer, _ := newTestTracer(t)
+	name := registerTraced(&fakeDriver{}, tracer)
+	db, err := sql.Open(name, "open-error")
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != errFake {
+		t.Errorf("ping error = %v, want %v", err, errFake)
+	}
+}
+
+func TestRegisterErrSkip(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	name := registerTraced(&skipDriver{}, tracer, WithSQLDBType(MYSQL))
+	db, err := sql.Open(name, fakeDsn)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name          string
+		fn            func(ctx context.Context) error
+		operationName string
+	}{
+		{
+			name: "exec with args",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", 1)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+		},
+		{
+			name: "exec without args",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "DELETE FROM users")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+		},
+		{
+			name: "query with args",
+			fn: func(ctx context.Context) error {
+				rows, err := db.QueryContext(ctx, "SELECT id FROM users WHERE id = ?", 1)
+				if err == nil {
+					rows.Close()
+				}
+				return err
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name: "query without args",
+			fn: func(ctx context.Context) error {
+				rows, err := db.QueryContext(ctx, "SELECT id FROM users")
+				if err == nil {
+					rows.Close()
+				}
+				return err
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				if err := tt.fn(ctx); err != nil {
+					t.Errorf("error: %v", err)
+				}
+			})
+			// the skipped statement is reported once by the prepared statement
+			checkSpan(t, spans, tt.operationName, false, nil)
+		})
+	}
+}
+
+func TestSetStartTime(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	start := time.Unix(1, 0)
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		createQuerySpanAt(ctx, tracer, &options{}, "execute", "", start).End()
+	})
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+	if spans[0].StartTime() != 1000 {
+		t.Errorf("start time = %d, want 1000", spans[0].StartTime())
+	}
+}
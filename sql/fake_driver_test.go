@@ -0,0 +1,382 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+const (
+	fakeDriverName       = "go2sky-fake"
+	fakeLegacyDriverName = "go2sky-fake-legacy"
+)
+
+var errFake = errors.New("fake error")
+
+func init() {
+	sql.Register(fakeDriverName, &fakeDriver{})
+	sql.Register(fakeLegacyDriverName, &legacyDriver{})
+}
+
+// fakeDriver is an in-memory driver.Driver, the statements containing "error" fail,
+// the queries return the rows 1, 2 and 3 of a single column
+type fakeDriver struct{}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	if strings.Contains(name, "open-error") {
+		return nil, errFake
+	}
+	return &fakeConn{dsn: name}, nil
+}
+
+type fakeConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type fakeConn struct {
+	dsn        string
+	failCommit bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "prepare error") {
+		return nil, errFake
+	}
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		return nil, errFake
+	}
+	c.failCommit = false
+	return &fakeTx{conn: c}, nil
+}
+
+func (c *fakeConn) Ping(context.Context) error {
+	if strings.Contains(c.dsn, "ping-error") {
+		return errFake
+	}
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	return c.exec(query)
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return fakeQuery(query)
+}
+
+func (c *fakeConn) exec(query string) (driver.Result, error) {
+	if strings.Contains(query, "fail commit") {
+		c.failCommit = true
+		return fakeResult{}, nil
+	}
+	if strings.Contains(query, "error") {
+		return nil, errFake
+	}
+	return fakeResult{}, nil
+}
+
+func fakeQuery(query string) (driver.Rows, error) {
+	if strings.Contains(query, "rows error") {
+		return &fakeRows{total: 3, fail: true}, nil
+	}
+	if strings.Contains(query, "error") {
+		return nil, errFake
+	}
+	return &fakeRows{total: 3}, nil
+}
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 7, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 2, nil
+}
+
+// fakeRows return the rows 1 to total, fail after the first row if fail is set
+type fakeRows struct {
+	total int
+	next  int
+	fail  bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(int) string {
+	return "BIGINT"
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.fail && r.next == 1 {
+		return errFake
+	}
+	if r.next >= r.total {
+		return io.EOF
+	}
+	r.next++
+	dest[0] = int64(r.next)
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return s.conn.exec(s.query)
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return fakeQuery(s.query)
+}
+
+func (s *fakeStmt) ExecContext(_ context.Context, _ []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(s.query)
+}
+
+func (s *fakeStmt) QueryContext(_ context.Context, _ []driver.NamedValue) (driver.Rows, error) {
+	return fakeQuery(s.query)
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	if tx.conn.failCommit {
+		return errFake
+	}
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
+
+// skipDriver is the fakeDriver skipping the statements with arguments by driver.ErrSkip,
+// like go-sql-driver/mysql without interpolateParams
+type skipDriver struct{}
+
+func (d *skipDriver) Open(name string) (driver.Conn, error) {
+	return &skipConn{fakeConn: &fakeConn{dsn: name}}, nil
+}
+
+type skipConn struct {
+	*fakeConn
+}
+
+func (c *skipConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.fakeConn.ExecContext(ctx, query, args)
+}
+
+func (c *skipConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.fakeConn.QueryContext(ctx, query, args)
+}
+
+// legacyDriver implements none of the optional interfaces of driver.Conn and driver.Stmt
+type legacyDriver struct{}
+
+func (d *legacyDriver) Open(name string) (driver.Conn, error) {
+	return &legacyConn{conn: &fakeConn{dsn: name}}, nil
+}
+
+type legacyConn struct {
+	conn *fakeConn
+}
+
+func (c *legacyConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &legacyStmt{stmt: stmt.(*fakeStmt)}, nil
+}
+
+func (c *legacyConn) Close() error {
+	return nil
+}
+
+func (c *legacyConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+type legacyStmt struct {
+	stmt *fakeStmt
+}
+
+func (s *legacyStmt) Close() error {
+	return nil
+}
+
+func (s *legacyStmt) NumInput() int {
+	return -1
+}
+
+func (s *legacyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.stmt.Exec(args)
+}
+
+func (s *legacyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.stmt.Query(args)
+}
+
+// mockReporter collect the reported segments
+type mockReporter struct {
+	segments chan []go2sky.ReportedSpan
+}
+
+func (r *mockReporter) Boot(string, string, []go2sky.AgentConfigChangeWatcher) {
+}
+
+func (r *mockReporter) Send(spans []go2sky.ReportedSpan) {
+	r.segments <- spans
+}
+
+func (r *mockReporter) Close() {
+}
+
+func newTestTracer(t *testing.T) (*go2sky.Tracer, *mockReporter) {
+	r := &mockReporter{segments: make(chan []go2sky.ReportedSpan, 16)}
+	tracer, err := go2sky.NewTracer("service", go2sky.WithReporter(r))
+	if err != nil {
+		t.Fatalf("new tracer error: %v", err)
+	}
+	return tracer, r
+}
+
+// trace run fn in a root span and return the reported spans except the root,
+// in the order of creating
+func trace(t *testing.T, tracer *go2sky.Tracer, r *mockReporter, fn func(ctx context.Context)) []go2sky.ReportedSpan {
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("root"))
+	if err != nil {
+		t.Fatalf("create root span error: %v", err)
+	}
+	fn(ctx)
+	span.End()
+	select {
+	case spans := <-r.segments:
+		return sortSpans(spans[:len(spans)-1])
+	case <-time.After(time.Second):
+		t.Fatal("segment is not reported")
+		return nil
+	}
+}
+
+// sortSpans sort the spans by span id, which is the order of creating
+func sortSpans(spans []go2sky.ReportedSpan) []go2sky.ReportedSpan {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Context().SpanID < spans[j].Context().SpanID
+	})
+	return spans
+}
+
+func findSpan(spans []go2sky.ReportedSpan, operationName string) go2sky.ReportedSpan {
+	for _, s := range spans {
+		if s.OperationName() == operationName {
+			return s
+		}
+	}
+	return nil
+}
+
+func spanTags(span go2sky.ReportedSpan) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range span.Tags() {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+// checkSpan check the operation name, error flag and tags of the only span
+func checkSpan(t *testing.T, spans []go2sky.ReportedSpan, operationName string, isError bool, tags map[string]string) go2sky.ReportedSpan {
+	t.Helper()
+	if len(spans) != 1 {
+		names := make([]string, 0, len(spans))
+		for _, s := range spans {
+			names = append(names, s.OperationName())
+		}
+		t.Fatalf("want 1 span of %s, got %v", operationName, names)
+	}
+	span := spans[0]
+	if span.OperationName() != operationName {
+		t.Errorf("operation name = %s, want %s", span.OperationName(), operationName)
+	}
+	if span.IsError() != isError {
+		t.Errorf("%s is error = %v, want %v", operationName, span.IsError(), isError)
+	}
+	got := spanTags(span)
+	for k, v := range tags {
+		if got[k] != v {
+			t.Errorf("%s tag %s = %q, want %q", operationName, k, got[k], v)
+		}
+	}
+	return span
+}
@@ -0,0 +1,185 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"strings"
+)
+
+// OperationNamer return the operation name of the span, operation is one of
+// ping, execute, query, begin, commit and rollback, query is empty if the
+// operation has no sql statement
+type OperationNamer func(operation, query string) string
+
+// sqlOperation derive "VERB table" from the query by a lightweight tokenizer,
+// the table is omitted if not found, an empty string is returned if the query
+// is not recognized
+func sqlOperation(query string) string {
+	tokens := sqlTokens(query)
+	if len(tokens) == 0 {
+		return ""
+	}
+	verb := strings.ToUpper(tokens[0])
+	if !isKeyword(verb) {
+		return ""
+	}
+
+	var table string
+	switch verb {
+	case "SELECT":
+		table = tokenAfter(tokens, "FROM")
+	case "INSERT", "REPLACE":
+		table = tokenAfter(tokens, "INTO")
+	case "DELETE":
+		table = tokenAfter(tokens, "FROM")
+	case "UPDATE":
+		table = skipModifiers(tokens[1:], "LOW_PRIORITY", "IGNORE", "ONLY")
+	case "CREATE", "DROP", "ALTER", "TRUNCATE":
+		if tokenIndex(tokens, "TABLE") > 0 || verb == "TRUNCATE" {
+			table = skipModifiers(tokens[1:], "TABLE", "IF", "NOT", "EXISTS", "TEMPORARY", "UNLOGGED")
+		}
+	case "CALL", "EXEC", "EXECUTE":
+		table = skipModifiers(tokens[1:])
+	}
+	if table == "" {
+		return verb
+	}
+	return verb + " " + table
+}
+
+// tokenAfter return the identifier after the first keyword at top level
+func tokenAfter(tokens []string, keyword string) string {
+	i := tokenIndex(tokens, keyword)
+	if i < 0 {
+		return ""
+	}
+	return skipModifiers(tokens[i+1:])
+}
+
+// tokenIndex return the index of the first keyword at top level
+func tokenIndex(tokens []string, keyword string) int {
+	depth := 0
+	for i, token := range tokens {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		default:
+			if depth == 0 && strings.EqualFold(token, keyword) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// skipModifiers return the first identifier after the modifiers
+func skipModifiers(tokens []string, modifiers ...string) string {
+	for _, token := range tokens {
+		skip := false
+		for _, m := range modifiers {
+			if strings.EqualFold(token, m) {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+		if token == "(" || token == ")" || token == "," {
+			return ""
+		}
+		return unquoteIdent(token)
+	}
+	return ""
+}
+
+// sqlTokens split the query into words and parentheses, literals and comments are skipped
+func sqlTokens(query string) []string {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(query)
+			}
+		case c == '\'':
+			i = skipQuoted(query, i)
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, query[i:i+1])
+			i++
+		case isIdentChar(c) || c == '"' || c == '`' || c == '[':
+			j := skipIdent(query, i)
+			tokens = append(tokens, query[i:j])
+			i = j
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipIdent return the index after the identifier starting at i,
+// the identifier may be quoted and qualified like `schema`.`table`
+func skipIdent(query string, i int) int {
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == '"' || c == '`':
+			i = skipQuoted(query, i)
+		case c == '[':
+			if j := strings.IndexByte(query[i:], ']'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case isIdentChar(c) || c == '.':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func unquoteIdent(ident string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '"', '`', '[', ']':
+			return -1
+		}
+		return r
+	}, ident)
+}
+
+func isKeyword(word string) bool {
+	for _, c := range word {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
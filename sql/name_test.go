@@ -0,0 +1,132 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSQLOperation(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT name FROM users WHERE id = ?", "SELECT users"},
+		{"/* comment */ select count(*) from `db`.`orders` o JOIN items i", "SELECT db.orders"},
+		{"-- comment\nSELECT * FROM (SELECT 1) t", "SELECT"},
+		{"INSERT INTO users (id) VALUES (?)", "INSERT users"},
+		{"insert ignore into [dbo].[orders] values (1)", "INSERT dbo.orders"},
+		{"REPLACE INTO users VALUES (1)", "REPLACE users"},
+		{"UPDATE LOW_PRIORITY users SET name = ?", "UPDATE users"},
+		{"DELETE FROM sessions WHERE expired", "DELETE sessions"},
+		{"CREATE TABLE IF NOT EXISTS users (id int)", "CREATE users"},
+		{"DROP TABLE IF EXISTS users", "DROP users"},
+		{"CREATE INDEX idx ON users (name)", "CREATE"},
+		{"TRUNCATE TABLE users", "TRUNCATE users"},
+		{"CALL refresh(1)", "CALL refresh"},
+		{"BEGIN", "BEGIN"},
+		{"", ""},
+		{"123", ""},
+	}
+	for _, tt := range tests {
+		if got := sqlOperation(tt.query); got != tt.want {
+			t.Errorf("sqlOperation(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		operationName string
+	}{
+		{
+			name:          "default",
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name:          "sql operation name",
+			opts:          []Option{WithSQLOperationName()},
+			operationName: "Mysql/Go2Sky/SELECT users",
+		},
+		{
+			name: "operation namer",
+			opts: []Option{WithOperationNamer(func(operation, query string) string {
+				return "db/" + operation + "/" + strings.Fields(query)[0]
+			})},
+			operationName: "db/query/SELECT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, tt.opts...)
+			defer db.Close()
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				var id int
+				if err := db.QueryRowContext(ctx, "SELECT id FROM users").Scan(&id); err != nil {
+					t.Errorf("query row error: %v", err)
+				}
+			})
+			checkSpan(t, spans, tt.operationName, false, nil)
+		})
+	}
+}
+
+func TestSQLOperationName(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithSQLOperationName())
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if err := db.PingContext(ctx); err != nil {
+			t.Errorf("ping error: %v", err)
+		}
+		stmt, err := db.PrepareContext(ctx, "INSERT INTO orders (id) VALUES (?)")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		defer stmt.Close()
+		if _, err := stmt.ExecContext(ctx, 1); err != nil {
+			t.Errorf("stmt exec error: %v", err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET age = 1"); err != nil {
+			t.Errorf("tx exec error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("commit error: %v", err)
+		}
+	})
+
+	// the operations without sql keep the default names
+	var names []string
+	for _, s := range spans {
+		names = append(names, strings.TrimPrefix(s.OperationName(), "Mysql/Go2Sky/"))
+	}
+	want := "ping,INSERT orders,begin,UPDATE users,commit"
+	if strings.Join(names, ",") != want {
+		t.Errorf("spans = %v, want %s", names, want)
+	}
+}
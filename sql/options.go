@@ -16,6 +16,11 @@
 
 package sql
 
+import (
+	"context"
+	"time"
+)
+
 // DBType database type
 type DBType string
 
@@ -24,10 +29,23 @@ const (
 	UNKNOWN DBType = "unknown"
 	// MYSQL mysql
 	MYSQL DBType = "mysql"
+	// POSTGRESQL postgresql, dsn of lib/pq and pgx
+	POSTGRESQL DBType = "postgresql"
+	// MSSQL sql server, dsn of go-mssqldb
+	MSSQL DBType = "mssql"
+	// ORACLE oracle, dsn of godror and go-ora
+	ORACLE DBType = "oracle"
+	// SQLITE sqlite, the database file is reported as peer
+	SQLITE DBType = "sqlite"
+	// CLICKHOUSE clickhouse, dsn of clickhouse-go
+	CLICKHOUSE DBType = "clickhouse"
 	// IPV4 others database type
 	IPV4 DBType = "others"
 )
 
+// SlowQueryCallback is called when the execution of the statement exceeds the slow query threshold
+type SlowQueryCallback func(ctx context.Context, statement string, duration time.Duration)
+
 // Option set plugin option
 type Option func(*options)
 
@@ -36,8 +54,27 @@ type options struct {
 	peer        string
 	componentID int32
 
-	reportQuery bool
-	reportParam bool
+	reportQuery  bool
+	reportParam  bool
+	reportResult bool
+
+	sqlOpName bool
+	opNamer   OperationNamer
+
+	sanitizer     Sanitizer
+	paramRedactor ParamRedactor
+	maxTagLength  int
+
+	slowQueryThreshold time.Duration
+	slowQueryCallback  SlowQueryCallback
+
+	statsInterval time.Duration
+	statsSink     StatsSink
+
+	traceRows bool
+	traceTx   bool
+
+	ambientContext func() context.Context
 }
 
 // WithSQLDBType set dbType option,
@@ -72,20 +109,171 @@ func WithParamReport() Option {
 	}
 }
 
-func (o options) getOpName(op string) string {
-	switch o.dbType {
+// WithSQLOperationName if set, the operation name is derived from the sql verb
+// and table, like Mysql/Go2Sky/SELECT users, instead of Mysql/Go2Sky/query
+func WithSQLOperationName() Option {
+	return func(o *options) {
+		o.sqlOpName = true
+	}
+}
+
+// WithOperationNamer set the func to name the spans by yourself
+func WithOperationNamer(namer OperationNamer) Option {
+	return func(o *options) {
+		o.opNamer = namer
+	}
+}
+
+// WithQuerySanitizer set the sanitizer to rewrite the reported sql, use ObfuscateLiterals
+// or ObfuscateLiteralsOf(dbType) to replace the string and number literals by ?
+func WithQuerySanitizer(sanitizer Sanitizer) Option {
+	return func(o *options) {
+		o.sanitizer = sanitizer
+	}
+}
+
+// WithParamRedactor set the redactor to format every reported parameter,
+// e.g. mask the passwords, parameters are formatted by %v by default
+func WithParamRedactor(redactor ParamRedactor) Option {
+	return func(o *options) {
+		o.paramRedactor = redactor
+	}
+}
+
+// WithMaxTagLength truncate the reported sql and parameters to at most n bytes
+func WithMaxTagLength(n int) Option {
+	return func(o *options) {
+		o.maxTagLength = n
+	}
+}
+
+// WithSlowQueryThreshold if set, the statement and a slow_query tag would be collected
+// when the execution exceeds the threshold, even if WithQueryReport is not set,
+// the callback is optional and called with the sanitized statement
+func WithSlowQueryThreshold(threshold time.Duration, callback ...SlowQueryCallback) Option {
+	return func(o *options) {
+		o.slowQueryThreshold = threshold
+		if len(callback) > 0 {
+			o.slowQueryCallback = callback[0]
+		}
+	}
+}
+
+// WithStatsCollector if set, the connection pool statistics of the DB opened by
+// Open or OpenDB are sampled on the interval and emitted to the sink until the DB
+// is closed, the interval is 10s if it is less than or equal to 0
+func WithStatsCollector(interval time.Duration, sink StatsSink) Option {
+	return func(o *options) {
+		o.statsInterval = interval
+		o.statsSink = sink
+	}
+}
+
+// WithResultReport if set, the rows affected and last insert id of the exec result would be collected
+func WithResultReport() Option {
+	return func(o *options) {
+		o.reportResult = true
+	}
+}
+
+// WithRowsTrace if set, the span of query of the driver level instrumentation ends when the
+// rows are closed, the number of rows scanned and the error of iteration would be collected.
+// Use TracedQueryContext of DB, Conn, Stmt and Tx for the same.
+func WithRowsTrace() Option {
+	return func(o *options) {
+		o.traceRows = true
+	}
+}
+
+// WithTransactionSpan if set, a local transaction span is opened at BeginTx of DB and Conn,
+// it parents the statements in the transaction and closes on commit or rollback with the
+// outcome tagged as db.transaction.outcome
+func WithTransactionSpan() Option {
+	return func(o *options) {
+		o.traceTx = true
+	}
+}
+
+// WithAmbientContext set the func returning the context of the non-context
+// methods like Exec, Query and Ping, context.Background() is used by default
+func WithAmbientContext(f func() context.Context) Option {
+	return func(o *options) {
+		o.ambientContext = f
+	}
+}
+
+func (o options) getOpName(op string, query string) string {
+	if o.opNamer != nil {
+		return o.opNamer(op, query)
+	}
+	if o.sqlOpName {
+		if name := sqlOperation(query); name != "" {
+			op = name
+		}
+	}
+
+	return OperationPrefix(o.dbType) + op
+}
+
+func (o *options) setComponentID() {
+	o.componentID = ComponentID(o.dbType)
+}
+
+// ComponentID return the component id of the database type defined in the
+// component-libraries.yml of SkyWalking
+func ComponentID(dbType DBType) int32 {
+	switch dbType {
 	case MYSQL:
-		return "Mysql/Go2Sky/" + op
+		return componentIDMysql
+	case POSTGRESQL:
+		return componentIDPostgresql
+	case MSSQL:
+		return componentIDMssql
+	case ORACLE:
+		return componentIDOracle
+	case SQLITE:
+		return componentIDSqlite
+	case CLICKHOUSE:
+		return componentIDClickhouse
 	default:
-		return "Sql/Go2Sky/" + op
+		return componentIDUnknown
 	}
 }
 
-func (o *options) setComponentID() {
-	switch o.dbType {
+// OperationPrefix return the prefix of the operation names of the database type, like Mysql/Go2Sky/
+func OperationPrefix(dbType DBType) string {
+	switch dbType {
 	case MYSQL:
-		o.componentID = componentIDMysql
+		return "Mysql/Go2Sky/"
+	case POSTGRESQL:
+		return "Postgresql/Go2Sky/"
+	case MSSQL:
+		return "Mssql/Go2Sky/"
+	case ORACLE:
+		return "Oracle/Go2Sky/"
+	case SQLITE:
+		return "Sqlite/Go2Sky/"
+	case CLICKHOUSE:
+		return "Clickhouse/Go2Sky/"
 	default:
-		o.componentID = componentIDUnknown
+		return "Sql/Go2Sky/"
+	}
+}
+
+// statement return the sanitized and truncated statement to report
+func (o *options) statement(query string) string {
+	if o.sanitizer != nil {
+		query = o.sanitizer(query)
+	}
+	return truncate(query, o.maxTagLength)
+}
+
+// context return the context of the non-context methods
+func (o *options) context() context.Context {
+	if o.ambientContext != nil {
+		if ctx := o.ambientContext(); ctx != nil {
+			return ctx
+		}
 	}
+	return context.Background()
 }
@@ -0,0 +1,75 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+// Rows wrap sql.Rows returned by TracedQueryContext, the span of the query ends
+// when the rows are closed or iterated to the end, and the number of rows
+// scanned and the error of iteration are reported
+type Rows struct {
+	*sql.Rows
+
+	span    go2sky.Span
+	scanned int64
+	once    sync.Once
+}
+
+func newRows(rows *sql.Rows, span go2sky.Span) *Rows {
+	return &Rows{
+		Rows: rows,
+		span: span,
+	}
+}
+
+// Next support trace, the span ends if there is no more row,
+// as the rows are closed by database/sql at that point
+func (r *Rows) Next() bool {
+	if !r.Rows.Next() {
+		r.end()
+		return false
+	}
+	r.scanned++
+	return true
+}
+
+// Close support trace
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.end()
+	return err
+}
+
+func (r *Rows) end() {
+	if r.span == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.span.Tag(tagDBRowsScanned, strconv.FormatInt(r.scanned, 10))
+		if err := r.Rows.Err(); err != nil {
+			r.span.Error(time.Now(), err.Error())
+		}
+		r.span.End()
+	})
+}
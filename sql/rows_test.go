@@ -0,0 +1,186 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestTracedQuery(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	var current context.Context
+	db := openFakeDB(t, tracer, WithAmbientContext(func() context.Context { return current }))
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("conn error: %v", err)
+	}
+	defer conn.Close()
+	stmt, err := db.PrepareContext(context.Background(), "SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("prepare error: %v", err)
+	}
+	defer stmt.Close()
+
+	tests := []struct {
+		name    string
+		query   func(ctx context.Context) (*Rows, error)
+		close   bool
+		scanned string
+		isError bool
+	}{
+		{
+			name: "db rows closed",
+			query: func(ctx context.Context) (*Rows, error) {
+				return db.TracedQueryContext(ctx, "SELECT id FROM users")
+			},
+			close:   true,
+			scanned: "3",
+		},
+		{
+			name: "db rows iterated to the end without closing",
+			query: func(ctx context.Context) (*Rows, error) {
+				return db.TracedQueryContext(ctx, "SELECT id FROM users")
+			},
+			scanned: "3",
+		},
+		{
+			name: "db rows error",
+			query: func(ctx context.Context) (*Rows, error) {
+				return db.TracedQuery("rows error")
+			},
+			close:   true,
+			scanned: "1",
+			isError: true,
+		},
+		{
+			name: "conn rows",
+			query: func(ctx context.Context) (*Rows, error) {
+				return conn.TracedQueryContext(ctx, "SELECT id FROM users")
+			},
+			scanned: "3",
+		},
+		{
+			name: "stmt rows",
+			query: func(ctx context.Context) (*Rows, error) {
+				return stmt.TracedQueryContext(ctx)
+			},
+			scanned: "3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				// the non-context methods use the ctx of the root span
+				current = ctx
+				rows, err := tt.query(ctx)
+				if err != nil {
+					t.Fatalf("query error: %v", err)
+				}
+				for rows.Next() {
+				}
+				if (rows.Err() != nil) != tt.isError {
+					t.Errorf("rows error = %v, want error %v", rows.Err(), tt.isError)
+				}
+				if tt.close {
+					rows.Close()
+					rows.Close()
+				}
+			})
+			checkSpan(t, spans, "Mysql/Go2Sky/query", tt.isError, map[string]string{"db.rows_scanned": tt.scanned})
+		})
+	}
+}
+
+func TestQueryReturnsSQLRows(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer)
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		var rows *sql.Rows
+		rows, err := db.QueryContext(ctx, "SELECT id FROM users")
+		if err != nil {
+			t.Fatalf("query error: %v", err)
+		}
+		defer rows.Close()
+	})
+	// the span ends when the rows are returned
+	span := checkSpan(t, spans, "Mysql/Go2Sky/query", false, nil)
+	if _, ok := spanTags(span)["db.rows_scanned"]; ok {
+		t.Error("rows scanned is tagged without tracing the rows")
+	}
+}
+
+func TestResultReport(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithResultReport())
+	defer db.Close()
+
+	tests := []struct {
+		name string
+		exec func(ctx context.Context) (sql.Result, error)
+	}{
+		{
+			name: "db exec",
+			exec: func(ctx context.Context) (sql.Result, error) {
+				return db.ExecContext(ctx, "DELETE FROM users")
+			},
+		},
+		{
+			name: "stmt exec",
+			exec: func(ctx context.Context) (sql.Result, error) {
+				stmt, err := db.PrepareContext(ctx, "DELETE FROM users")
+				if err != nil {
+					return nil, err
+				}
+				defer stmt.Close()
+				return stmt.ExecContext(ctx)
+			},
+		},
+		{
+			name: "tx exec",
+			exec: func(ctx context.Context) (sql.Result, error) {
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					return nil, err
+				}
+				defer tx.Rollback()
+				return tx.ExecContext(ctx, "DELETE FROM users")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				if _, err := tt.exec(ctx); err != nil {
+					t.Errorf("exec error: %v", err)
+				}
+			})
+			span := findSpan(spans, "Mysql/Go2Sky/execute")
+			if span == nil {
+				t.Fatal("execute span is not reported")
+			}
+			tags := spanTags(span)
+			if tags["db.rows_affected"] != "2" || tags["db.last_insert_id"] != "7" {
+				t.Errorf("result tags = %v", tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Sanitizer rewrite the query before it is reported, e.g. obfuscate the literals
+type Sanitizer func(query string) string
+
+// ParamRedactor format the parameter to report, ordinal starts from 1,
+// name is empty unless the parameter is passed by sql.Named
+type ParamRedactor func(ordinal int, name string, value interface{}) string
+
+const truncatedSuffix = "..."
+
+// literalSyntax is the syntax of the literals differing between the databases
+type literalSyntax struct {
+	// doubleQuotedString is set if "..." is a string literal instead of a quoted identifier
+	doubleQuotedString bool
+	// backslashEscape is set if \ escapes the quote in '...'
+	backslashEscape bool
+	// escapeString is set if E'...' is a string literal with backslash escapes
+	escapeString bool
+	// dollarQuoted is set if $$...$$ and $tag$...$tag$ are string literals
+	dollarQuoted bool
+}
+
+var (
+	mysqlSyntax      = literalSyntax{doubleQuotedString: true, backslashEscape: true}
+	postgresqlSyntax = literalSyntax{escapeString: true, dollarQuoted: true}
+	clickhouseSyntax = literalSyntax{backslashEscape: true}
+	standardSyntax   = literalSyntax{}
+)
+
+// ObfuscateLiterals is the built-in Sanitizer replacing the string and number literals by ?,
+// identifiers, quoted identifiers and placeholders are kept. The literals are parsed by the
+// default syntax of MySQL, where "..." is a string literal and \ escapes the quote,
+// use ObfuscateLiteralsOf for the other databases.
+func ObfuscateLiterals(query string) string {
+	return obfuscateLiterals(query, mysqlSyntax)
+}
+
+// ObfuscateLiteralsOf return the Sanitizer like ObfuscateLiterals, the literals are
+// parsed by the syntax of dbType, e.g. the standard conforming strings and $$...$$
+// of PostgreSQL. The syntax of MySQL is used for the unknown types.
+func ObfuscateLiteralsOf(dbType DBType) Sanitizer {
+	syntax := mysqlSyntax
+	switch dbType {
+	case POSTGRESQL:
+		syntax = postgresqlSyntax
+	case CLICKHOUSE:
+		syntax = clickhouseSyntax
+	case MSSQL, ORACLE, SQLITE:
+		syntax = standardSyntax
+	}
+	return func(query string) string {
+		return obfuscateLiterals(query, syntax)
+	}
+}
+
+func obfuscateLiterals(query string, syntax literalSyntax) string {
+	sb := strings.Builder{}
+	sb.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			// string literal
+			sb.WriteByte('?')
+			i = skipString(query, i, syntax.backslashEscape)
+		case c == '"' && syntax.doubleQuotedString:
+			sb.WriteByte('?')
+			i = skipString(query, i, syntax.backslashEscape)
+		case c == '"' || c == '`':
+			// quoted identifier
+			j := skipString(query, i, false)
+			sb.WriteString(query[i:j])
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			if i > 0 && (isIdentChar(query[i-1]) || query[i-1] == ':') {
+				// part of identifier or placeholder like $1 and :1
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+			sb.WriteByte('?')
+			i = skipNumber(query, i)
+		case c == '$' && syntax.dollarQuoted && dollarTag(query, i) != "":
+			sb.WriteByte('?')
+			tag := dollarTag(query, i)
+			if j := strings.Index(query[i+len(tag):], tag); j >= 0 {
+				i += len(tag) + j + len(tag)
+			} else {
+				i = len(query)
+			}
+		case isIdentChar(c):
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			if syntax.escapeString && j == i+1 && (c == 'E' || c == 'e') && j < len(query) && query[j] == '\'' {
+				// escape string like E'\n'
+				sb.WriteByte('?')
+				i = skipString(query, j, true)
+				continue
+			}
+			sb.WriteString(query[i:j])
+			i = j
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// dollarTag return the tag like $$ or $body$ starting at i, or empty if it's not a tag,
+// e.g. the placeholder $1
+func dollarTag(query string, i int) string {
+	j := i + 1
+	if j < len(query) && isDigit(query[j]) {
+		return ""
+	}
+	for j < len(query) && query[j] != '$' && isIdentChar(query[j]) {
+		j++
+	}
+	if j < len(query) && query[j] == '$' {
+		return query[i : j+1]
+	}
+	return ""
+}
+
+// skipQuoted return the index after the quoted literal or identifier starting at i,
+// the quote is escaped by doubling, or backslash in the string literal
+func skipQuoted(query string, i int) int {
+	return skipString(query, i, query[i] == '\'')
+}
+
+// skipString return the index after the quoted literal starting at i, the quote
+// is escaped by doubling, or backslash if backslashEscape is set
+func skipString(query string, i int, backslashEscape bool) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if backslashEscape {
+				j++
+			}
+		case quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+// skipNumber return the index after the number literal starting at i
+func skipNumber(query string, i int) int {
+	j := i
+	if strings.HasPrefix(query[j:], "0x") || strings.HasPrefix(query[j:], "0X") {
+		j += 2
+		for j < len(query) && strings.IndexByte("0123456789abcdefABCDEF", query[j]) >= 0 {
+			j++
+		}
+		return j
+	}
+	for j < len(query) && (isDigit(query[j]) || query[j] == '.') {
+		j++
+	}
+	if j < len(query) && (query[j] == 'e' || query[j] == 'E') {
+		k := j + 1
+		if k < len(query) && (query[k] == '+' || query[k] == '-') {
+			k++
+		}
+		if k < len(query) && isDigit(query[k]) {
+			j = k
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+		}
+	}
+	return j
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= utf8.RuneSelf
+}
+
+// truncate cut the value to at most max bytes without breaking a rune,
+// max less than or equal to 0 means no limit
+func truncate(value string, max int) string {
+	if max <= 0 || len(value) <= max {
+		return value
+	}
+	suffix := truncatedSuffix
+	if max <= len(suffix) {
+		suffix = ""
+	}
+	cut := max - len(suffix)
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut] + suffix
+}
@@ -0,0 +1,213 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestObfuscateLiterals(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "SELECT * FROM users WHERE name = 'O''Brien' AND age > 30",
+			want:  "SELECT * FROM users WHERE name = ? AND age > ?",
+		},
+		{
+			query: "SELECT * FROM t1 WHERE a = $1 AND b = :2 AND c IN (1, 2.5, -3e10, 0xFF, .5)",
+			want:  "SELECT * FROM t1 WHERE a = $1 AND b = :2 AND c IN (?, ?, -?, ?, ?)",
+		},
+		{
+			query: "INSERT INTO `t2` (`c3`) VALUES ('a\\'b', 42)",
+			want:  "INSERT INTO `t2` (`c3`) VALUES (?, ?)",
+		},
+		{
+			// double quoted string of MySQL
+			query: "SELECT * FROM users WHERE password = \"se\\\"cret\" AND name = \"x\"",
+			want:  "SELECT * FROM users WHERE password = ? AND name = ?",
+		},
+		{
+			query: "UPDATE t SET v = 'unterminated",
+			want:  "UPDATE t SET v = ?",
+		},
+	}
+	for _, tt := range tests {
+		if got := ObfuscateLiterals(tt.query); got != tt.want {
+			t.Errorf("ObfuscateLiterals(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestObfuscateLiteralsOf(t *testing.T) {
+	tests := []struct {
+		dbType DBType
+		query  string
+		want   string
+	}{
+		{
+			dbType: MYSQL,
+			query:  "SELECT \"secret\", 'a\\'b'",
+			want:   "SELECT ?, ?",
+		},
+		{
+			dbType: UNKNOWN,
+			query:  "SELECT \"secret\"",
+			want:   "SELECT ?",
+		},
+		{
+			// standard conforming strings
+			dbType: POSTGRESQL,
+			query:  "SELECT 'C:\\', 'secret' FROM \"users\" WHERE id = $1",
+			want:   "SELECT ?, ? FROM \"users\" WHERE id = $1",
+		},
+		{
+			dbType: POSTGRESQL,
+			query:  "SELECT E'a\\'b', 'c'",
+			want:   "SELECT ?, ?",
+		},
+		{
+			dbType: POSTGRESQL,
+			query:  "SELECT $$secret$$, $body$it's $$ secret$body$, $2",
+			want:   "SELECT ?, ?, $2",
+		},
+		{
+			dbType: POSTGRESQL,
+			query:  "SELECT $$unterminated",
+			want:   "SELECT ?",
+		},
+		{
+			dbType: MSSQL,
+			query:  "SELECT 'C:\\', 'secret' FROM [users]",
+			want:   "SELECT ?, ? FROM [users]",
+		},
+		{
+			dbType: ORACLE,
+			query:  "SELECT 'C:\\', 'secret' FROM \"USERS\"",
+			want:   "SELECT ?, ? FROM \"USERS\"",
+		},
+		{
+			dbType: SQLITE,
+			query:  "SELECT 'it''s', 'C:\\' FROM \"users\"",
+			want:   "SELECT ?, ? FROM \"users\"",
+		},
+		{
+			dbType: CLICKHOUSE,
+			query:  "SELECT 'a\\'b', 'c' FROM \"db\".`t`",
+			want:   "SELECT ?, ? FROM \"db\".`t`",
+		},
+	}
+	for _, tt := range tests {
+		if got := ObfuscateLiteralsOf(tt.dbType)(tt.query); got != tt.want {
+			t.Errorf("ObfuscateLiteralsOf(%s)(%q) = %q, want %q", tt.dbType, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		value string
+		max   int
+		want  string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 8, "hello..."},
+		{"héllo wörld", 6, "hé..."},
+		{"hello", 2, "he"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.value, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.value, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeAndRedact(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer,
+		WithQueryReport(),
+		WithParamReport(),
+		WithQuerySanitizer(ObfuscateLiterals),
+		WithParamRedactor(func(ordinal int, name string, value interface{}) string {
+			if name == "password" {
+				return "***"
+			}
+			return "v"
+		}),
+		WithMaxTagLength(32),
+	)
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		_, err := db.ExecContext(ctx, "UPDATE users SET name = 'foo', age = 10 WHERE id = ? AND password = ?",
+			1, sql.Named("password", "secret"))
+		if err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+	})
+	checkSpan(t, spans, "Mysql/Go2Sky/execute", false, map[string]string{
+		"db.statement":      "UPDATE users SET name = ?, ag...",
+		"db.sql.parameters": "v, ***, ",
+	})
+}
+
+func TestParamReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		params string
+	}{
+		{
+			name:   "default",
+			params: "1, secret, ",
+		},
+		{
+			name: "redactor",
+			opts: []Option{WithParamRedactor(func(ordinal int, name string, value interface{}) string {
+				if ordinal == 2 {
+					return "***"
+				}
+				return name
+			})},
+			params: "id, ***, ",
+		},
+		{
+			name:   "max tag length",
+			opts:   []Option{WithMaxTagLength(6)},
+			params: "1, ...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, r := newTestTracer(t)
+			db := openFakeDB(t, tracer, append([]Option{WithParamReport()}, tt.opts...)...)
+			defer db.Close()
+
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ? AND password = ?",
+					sql.Named("id", 1), "secret"); err != nil {
+					t.Errorf("exec error: %v", err)
+				}
+			})
+			checkSpan(t, spans, "Mysql/Go2Sky/execute", false, map[string]string{"db.sql.parameters": tt.params})
+		})
+	}
+}
@@ -0,0 +1,363 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkyAPM/go2sky"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+const fakeDsn = "user:password@tcp(127.0.0.1:3306)/db"
+
+func openFakeDB(t *testing.T, tracer *go2sky.Tracer, opts ...Option) *DB {
+	opts = append([]Option{WithSQLDBType(MYSQL)}, opts...)
+	db, err := Open(fakeDriverName, fakeDsn, tracer, opts...)
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	return db
+}
+
+func TestDB(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithQueryReport(), WithParamReport(), WithResultReport())
+	defer db.Close()
+
+	tests := []struct {
+		name          string
+		fn            func(ctx context.Context) error
+		operationName string
+		isError       bool
+		tags          map[string]string
+	}{
+		{
+			name:          "ping",
+			fn:            db.PingContext,
+			operationName: "Mysql/Go2Sky/ping",
+		},
+		{
+			name: "exec",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "INSERT INTO users (id) VALUES (?)", 1)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			tags: map[string]string{
+				"db.statement":      "INSERT INTO users (id) VALUES (?)",
+				"db.sql.parameters": "1, ",
+				"db.rows_affected":  "2",
+				"db.last_insert_id": "7",
+			},
+		},
+		{
+			name: "exec error",
+			fn: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "exec error")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			isError:       true,
+		},
+		{
+			name: "query",
+			fn: func(ctx context.Context) error {
+				rows, err := db.QueryContext(ctx, "SELECT id FROM users WHERE id > ?", 0)
+				if err != nil {
+					return err
+				}
+				return rows.Close()
+			},
+			operationName: "Mysql/Go2Sky/query",
+			tags: map[string]string{
+				"db.statement":      "SELECT id FROM users WHERE id > ?",
+				"db.sql.parameters": "0, ",
+			},
+		},
+		{
+			name: "query error",
+			fn: func(ctx context.Context) error {
+				_, err := db.QueryContext(ctx, "query error")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/query",
+			isError:       true,
+		},
+		{
+			name: "query row",
+			fn: func(ctx context.Context) error {
+				var id int
+				return db.QueryRowContext(ctx, "SELECT id FROM users").Scan(&id)
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name: "stmt exec",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "DELETE FROM users")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(ctx)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			tags: map[string]string{
+				"db.statement":     "DELETE FROM users",
+				"db.rows_affected": "2",
+			},
+		},
+		{
+			name: "stmt query",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "SELECT id FROM users")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				rows, err := stmt.QueryContext(ctx)
+				if err != nil {
+					return err
+				}
+				return rows.Close()
+			},
+			operationName: "Mysql/Go2Sky/query",
+			tags: map[string]string{
+				"db.statement": "SELECT id FROM users",
+			},
+		},
+		{
+			name: "stmt query row",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "SELECT id FROM users")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				var id int
+				return stmt.QueryRowContext(ctx).Scan(&id)
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name: "stmt exec error",
+			fn: func(ctx context.Context) error {
+				stmt, err := db.PrepareContext(ctx, "stmt error")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(ctx)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			isError:       true,
+		},
+		{
+			name: "conn ping",
+			fn: func(ctx context.Context) error {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				return conn.PingContext(ctx)
+			},
+			operationName: "Mysql/Go2Sky/ping",
+		},
+		{
+			name: "conn exec",
+			fn: func(ctx context.Context) error {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				_, err = conn.ExecContext(ctx, "UPDATE users SET name = ?", "foo")
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+			tags: map[string]string{
+				"db.statement":      "UPDATE users SET name = ?",
+				"db.sql.parameters": "foo, ",
+			},
+		},
+		{
+			name: "conn query",
+			fn: func(ctx context.Context) error {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				rows, err := conn.QueryContext(ctx, "SELECT id FROM users")
+				if err != nil {
+					return err
+				}
+				return rows.Close()
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name: "conn query row",
+			fn: func(ctx context.Context) error {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				var id int
+				return conn.QueryRowContext(ctx, "SELECT id FROM users").Scan(&id)
+			},
+			operationName: "Mysql/Go2Sky/query",
+		},
+		{
+			name: "conn stmt exec",
+			fn: func(ctx context.Context) error {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				stmt, err := conn.PrepareContext(ctx, "DELETE FROM users")
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(ctx)
+				return err
+			},
+			operationName: "Mysql/Go2Sky/execute",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				err = tt.fn(ctx)
+			})
+			if (err != nil) != tt.isError {
+				t.Errorf("error = %v, want error %v", err, tt.isError)
+			}
+			span := checkSpan(t, spans, tt.operationName, tt.isError, tt.tags)
+			if span.Peer() != "127.0.0.1:3306" {
+				t.Errorf("peer = %s, want 127.0.0.1:3306", span.Peer())
+			}
+			if span.ComponentID() != componentIDMysql {
+				t.Errorf("component id = %d, want %d", span.ComponentID(), componentIDMysql)
+			}
+			if span.SpanType() != agentv3.SpanType_Exit {
+				t.Errorf("span type = %v, want Exit", span.SpanType())
+			}
+			if span.SpanLayer() != agentv3.SpanLayer_Database {
+				t.Errorf("span layer = %v, want Database", span.SpanLayer())
+			}
+			tags := spanTags(span)
+			if tags["db.type"] != "mysql" || tags["db.instance"] != "127.0.0.1:3306" {
+				t.Errorf("db tags = %v", tags)
+			}
+		})
+	}
+}
+
+func TestDBWithoutReport(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer)
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", 1); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+	})
+	span := checkSpan(t, spans, "Mysql/Go2Sky/execute", false, nil)
+	for _, key := range []string{"db.statement", "db.sql.parameters", "db.rows_affected", "db.last_insert_id"} {
+		if _, ok := spanTags(span)[key]; ok {
+			t.Errorf("tag %s should not be reported", key)
+		}
+	}
+}
+
+func TestDBPingError(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db, err := Open(fakeDriverName, "ping-error@tcp(127.0.0.1:3306)/db", tracer, WithSQLDBType(MYSQL))
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if err := db.PingContext(ctx); err != errFake {
+			t.Errorf("ping error = %v, want %v", err, errFake)
+		}
+	})
+	checkSpan(t, spans, "Mysql/Go2Sky/ping", true, nil)
+}
+
+func TestTracingNeverBreaksQuery(t *testing.T) {
+	db, err := Open(fakeDriverName, fakeDsn, nil, WithSQLDBType(MYSQL), WithTransactionSpan(), WithRowsTrace())
+	if err != nil {
+		t.Fatalf("open db error: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.PingContext(ctx); err != nil {
+		t.Errorf("ping error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM users"); err != nil {
+		t.Errorf("exec error: %v", err)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	rows.Close()
+
+	var id int
+	row := db.QueryRowContext(ctx, "SELECT id FROM users")
+	if row == nil {
+		t.Fatal("query row returns nil")
+	}
+	if err := row.Scan(&id); err != nil || id != 1 {
+		t.Errorf("scan = %d, %v", id, err)
+	}
+
+	stmt, err := db.PrepareContext(ctx, "SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("prepare error: %v", err)
+	}
+	defer stmt.Close()
+	if row := stmt.QueryRowContext(ctx); row == nil {
+		t.Fatal("stmt query row returns nil")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin error: %v", err)
+	}
+	if row := tx.QueryRowContext(ctx, "SELECT id FROM users"); row == nil {
+		t.Fatal("tx query row returns nil")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("commit error: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const defaultStatsInterval = 10 * time.Second
+
+// StatsSink receive the connection pool statistics sampled periodically,
+// labels contain the peer and db_type of the DB, and must not be modified
+type StatsSink func(labels map[string]string, stats sql.DBStats)
+
+// statsCollector sample the sql.DBStats on an interval until stopped
+type statsCollector struct {
+	db       *sql.DB
+	sink     StatsSink
+	labels   map[string]string
+	interval time.Duration
+
+	done chan struct{}
+	once sync.Once
+}
+
+func startStatsCollector(db *sql.DB, opts *options) *statsCollector {
+	if opts.statsSink == nil {
+		return nil
+	}
+	interval := opts.statsInterval
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	c := &statsCollector{
+		db:   db,
+		sink: opts.statsSink,
+		labels: map[string]string{
+			"peer":    opts.peer,
+			"db_type": string(opts.dbType),
+		},
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *statsCollector) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sink(c.labels, c.db.Stats())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *statsCollector) stop() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
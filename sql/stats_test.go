@@ -0,0 +1,95 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStatsCollector(t *testing.T) {
+	type sample struct {
+		labels map[string]string
+		stats  sql.DBStats
+	}
+	samples := make(chan sample, 16)
+	db := openFakeDB(t, nil, WithStatsCollector(10*time.Millisecond, func(labels map[string]string, stats sql.DBStats) {
+		samples <- sample{labels: labels, stats: stats}
+	}))
+	if err := db.Ping(); err != nil {
+		t.Fatalf("ping error: %v", err)
+	}
+
+	select {
+	case s := <-samples:
+		if s.labels["peer"] != "127.0.0.1:3306" || s.labels["db_type"] != "mysql" {
+			t.Errorf("labels = %v", s.labels)
+		}
+		if s.stats.OpenConnections != 1 {
+			t.Errorf("open connections = %d, want 1", s.stats.OpenConnections)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stats are not collected")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Errorf("close error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for len(samples) > 0 {
+		<-samples
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(samples) != 0 {
+		t.Error("stats are collected after close")
+	}
+}
+
+func TestStatsCollectorOpenDB(t *testing.T) {
+	samples := make(chan map[string]string, 16)
+	db := OpenDB(&fakeConnector{dsn: fakeDsn, driver: &fakeDriver{}}, nil,
+		WithSQLDBType(POSTGRESQL), WithPeerAddr("pg:5432"),
+		WithStatsCollector(10*time.Millisecond, func(labels map[string]string, stats sql.DBStats) {
+			samples <- labels
+		}))
+	defer db.Close()
+
+	select {
+	case labels := <-samples:
+		if labels["peer"] != "pg:5432" || labels["db_type"] != "postgresql" {
+			t.Errorf("labels = %v", labels)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stats are not collected")
+	}
+}
+
+func TestStatsCollectorOptions(t *testing.T) {
+	if c := startStatsCollector(nil, &options{}); c != nil {
+		t.Error("stats collector is started without sink")
+	}
+	c := startStatsCollector(nil, &options{statsSink: func(map[string]string, sql.DBStats) {}})
+	if c.interval != defaultStatsInterval {
+		t.Errorf("interval = %s, want %s", c.interval, defaultStatsInterval)
+	}
+	// stop more than once
+	c.stop()
+	c.stop()
+	var nilCollector *statsCollector
+	nilCollector.stop()
+}
@@ -29,67 +29,111 @@ type Stmt struct {
 	*sql.Stmt
 
 	db    *DB
+	tx    *Tx
 	query string
 }
 
+// context return the context of the non-context methods
+func (s *Stmt) context() context.Context {
+	if s.tx != nil {
+		return s.tx.ctx
+	}
+	return s.db.opts.context()
+}
+
+// spanContext return the context to create the span of statement
+func (s *Stmt) spanContext(ctx context.Context) context.Context {
+	if s.tx != nil {
+		return s.tx.spanContext(ctx)
+	}
+	return ctx
+}
+
+// Exec support trace
+func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.ExecContext(s.context(), args...)
+}
+
 // ExecContext support trace
 func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
-	span, err := createSpan(ctx, s.db.tracer, s.db.opts, "execute")
-	if err != nil {
-		return nil, err
-	}
+	span := createQuerySpan(s.spanContext(ctx), s.db.tracer, s.db.opts, "execute", s.query)
 	defer span.End()
 
-	if s.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, s.query)
-	}
-	if s.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, s.db.opts, s.query, args)
 
+	start := time.Now()
 	res, err := s.Stmt.ExecContext(ctx, args...)
+	tagSlowQuery(ctx, span, s.db.opts, s.query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		return res, err
 	}
-	return res, err
+	tagResult(span, s.db.opts, res)
+	return res, nil
+}
+
+// Query support trace
+func (s *Stmt) Query(args ...interface{}) (*sql.Rows, error) {
+	return s.QueryContext(s.context(), args...)
 }
 
 // QueryContext support trace
 func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
-	span, err := createSpan(ctx, s.db.tracer, s.db.opts, "query")
+	rows, span, err := s.queryWithSpan(ctx, args)
 	if err != nil {
 		return nil, err
 	}
-	defer span.End()
+	span.End()
+	return rows, nil
+}
 
-	if s.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, s.query)
-	}
-	if s.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
+// TracedQuery support trace, refer to TracedQueryContext
+func (s *Stmt) TracedQuery(args ...interface{}) (*Rows, error) {
+	return s.TracedQueryContext(s.context(), args...)
+}
+
+// TracedQueryContext support trace like QueryContext, but the span of query ends when the
+// returned rows are closed or iterated to the end, the number of rows scanned and the
+// error of iteration are reported
+func (s *Stmt) TracedQueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	rows, span, err := s.queryWithSpan(ctx, args)
+	if err != nil {
+		return nil, err
 	}
+	return newRows(rows, span), nil
+}
+
+// queryWithSpan returns the rows with the span of query, the span is ended on error
+func (s *Stmt) queryWithSpan(ctx context.Context, args []interface{}) (*sql.Rows, go2sky.Span, error) {
+	span := createQuerySpan(s.spanContext(ctx), s.db.tracer, s.db.opts, "query", s.query)
 
+	tagQuery(span, s.db.opts, s.query, args)
+
+	start := time.Now()
 	rows, err := s.Stmt.QueryContext(ctx, args...)
+	tagSlowQuery(ctx, span, s.db.opts, s.query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, nil, err
 	}
-	return rows, err
+	return rows, span, nil
+}
+
+// QueryRow support trace
+func (s *Stmt) QueryRow(args ...interface{}) *sql.Row {
+	return s.QueryRowContext(s.context(), args...)
 }
 
 // QueryRowContext support trace
 func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
-	span, err := createSpan(ctx, s.db.tracer, s.db.opts, "query")
-	if err != nil {
-		return nil
-	}
+	span := createQuerySpan(s.spanContext(ctx), s.db.tracer, s.db.opts, "query", s.query)
 	defer span.End()
 
-	if s.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, s.query)
-	}
-	if s.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, s.db.opts, s.query, args)
 
-	return s.Stmt.QueryRowContext(ctx, args...)
+	start := time.Now()
+	row := s.Stmt.QueryRowContext(ctx, args...)
+	tagSlowQuery(ctx, span, s.db.opts, s.query, start)
+	return row
 }
@@ -19,9 +19,11 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"sync"
 	"time"
 
 	"github.com/SkyAPM/go2sky"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
 )
 
 // Tx wrap sql.Tx and support trace
@@ -30,40 +32,115 @@ type Tx struct {
 
 	db  *DB
 	ctx context.Context
+
+	// span is the transaction span if WithTransactionSpan is set
+	span            go2sky.Span
+	parentSpanID    int32
+	parentSegmentID string
+	once            sync.Once
 }
 
-// Commit support trace
-func (tx *Tx) Commit() (err error) {
-	span, err := createSpan(tx.ctx, tx.db.tracer, tx.db.opts, "commit")
-	if err != nil {
-		return err
+// beginTx begin the transaction by begin, if WithTransactionSpan is set, a local
+// transaction span is created as the parent of the statements in the transaction
+func beginTx(ctx context.Context, db *DB, begin func(context.Context) (*sql.Tx, error)) (*Tx, error) {
+	tx := &Tx{
+		db:  db,
+		ctx: ctx,
+	}
+	if db.opts.traceTx {
+		if span, txCtx := createTxSpan(ctx, db.tracer, db.opts); span != nil {
+			tx.span = span
+			tx.ctx = txCtx
+			tx.parentSpanID = go2sky.SpanID(ctx)
+			tx.parentSegmentID = go2sky.TraceSegmentID(ctx)
+		}
 	}
-	defer span.End()
 
-	err = tx.Tx.Commit()
+	span := createSpan(tx.ctx, db.tracer, db.opts, "begin")
+	sqlTx, err := begin(ctx)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
-		return err
+	}
+	span.End()
+	if err != nil {
+		tx.end("begin", err)
+		return nil, err
 	}
 
-	return nil
+	tx.Tx = sqlTx
+	return tx, nil
 }
 
-// Rollback support trace
-func (tx *Tx) Rollback() (err error) {
-	span, err := createSpan(tx.ctx, tx.db.tracer, tx.db.opts, "rollback")
+// createTxSpan create the local transaction span, nil is returned if the span can not be created
+func createTxSpan(ctx context.Context, tracer *go2sky.Tracer, opts *options) (go2sky.Span, context.Context) {
+	if tracer == nil || ctx == nil {
+		return nil, nil
+	}
+	s, nCtx, err := tracer.CreateLocalSpan(ctx,
+		go2sky.WithOperationName(opts.getOpName("transaction", "")),
+	)
 	if err != nil {
-		return err
+		return nil, nil
 	}
-	defer span.End()
+	s.SetComponent(opts.componentID)
+	s.SetSpanLayer(agentv3.SpanLayer_Database)
+	s.Tag(go2sky.TagDBType, string(opts.dbType))
+	s.Tag(go2sky.TagDBInstance, opts.peer)
+	return s, nCtx
+}
+
+// end the transaction span with the outcome, only the first outcome is reported
+func (tx *Tx) end(outcome string, err error) {
+	if tx.span == nil {
+		return
+	}
+	tx.once.Do(func() {
+		tx.span.Tag(tagDBTxOutcome, outcome)
+		if err != nil {
+			tx.span.Error(time.Now(), err.Error())
+		}
+		tx.span.End()
+	})
+}
+
+// spanContext return the context to create the span of statement
+func (tx *Tx) spanContext(ctx context.Context) context.Context {
+	id := go2sky.SpanID(ctx)
+	if id == go2sky.EmptySpanID {
+		// if ctx do not contain parent span, use transaction ctx instead
+		return tx.ctx
+	}
+	if tx.span != nil && id == tx.parentSpanID && go2sky.TraceSegmentID(ctx) == tx.parentSegmentID {
+		// ctx began the transaction, use the transaction span as parent instead
+		return tx.ctx
+	}
+	return ctx
+}
+
+// Commit support trace
+func (tx *Tx) Commit() error {
+	span := createSpan(tx.ctx, tx.db.tracer, tx.db.opts, "commit")
 
-	err = tx.Tx.Rollback()
+	err := tx.Tx.Commit()
 	if err != nil {
 		span.Error(time.Now(), err.Error())
-		return err
 	}
+	span.End()
+	tx.end("commit", err)
+	return err
+}
+
+// Rollback support trace
+func (tx *Tx) Rollback() error {
+	span := createSpan(tx.ctx, tx.db.tracer, tx.db.opts, "rollback")
 
-	return nil
+	err := tx.Tx.Rollback()
+	if err != nil {
+		span.Error(time.Now(), err.Error())
+	}
+	span.End()
+	tx.end("rollback", err)
+	return err
 }
 
 // Prepare support trace
@@ -75,6 +152,7 @@ func (tx *Tx) Prepare(query string) (*Stmt, error) {
 	return &Stmt{
 		Stmt:  stmt,
 		db:    tx.db,
+		tx:    tx,
 		query: query,
 	}, nil
 }
@@ -88,16 +166,23 @@ func (tx *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	return &Stmt{
 		Stmt:  stmt,
 		db:    tx.db,
+		tx:    tx,
 		query: query,
 	}, nil
 }
 
+// Stmt support trace
+func (tx *Tx) Stmt(stmt *Stmt) *Stmt {
+	return tx.StmtContext(tx.ctx, stmt)
+}
+
 // StmtContext support trace
 func (tx *Tx) StmtContext(ctx context.Context, stmt *Stmt) *Stmt {
 	st := tx.Tx.StmtContext(ctx, stmt.Stmt)
 	return &Stmt{
 		Stmt:  st,
 		db:    tx.db,
+		tx:    tx,
 		query: stmt.query,
 	}
 }
@@ -109,84 +194,84 @@ func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
 
 // ExecContext support trace
 func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	if id := go2sky.SpanID(ctx); id == go2sky.EmptySpanID {
-		// if ctx do not contain parent span, use transaction ctx instead
-		ctx = tx.ctx
-	}
-	span, err := createSpan(ctx, tx.db.tracer, tx.db.opts, "execute")
-	if err != nil {
-		return nil, err
-	}
+	span := createQuerySpan(tx.spanContext(ctx), tx.db.tracer, tx.db.opts, "execute", query)
 	defer span.End()
 
-	if tx.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if tx.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, tx.db.opts, query, args)
 
+	start := time.Now()
 	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, tx.db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		return res, err
 	}
-	return res, err
+	tagResult(span, tx.db.opts, res)
+	return res, nil
 }
 
 // Query support trace
 func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return tx.QueryContext(tx.ctx, query, args)
+	return tx.QueryContext(tx.ctx, query, args...)
 }
 
 // QueryContext support trace
 func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	if id := go2sky.SpanID(ctx); id == go2sky.EmptySpanID {
-		// if ctx do not contain parent span, use transaction ctx instead
-		ctx = tx.ctx
-	}
-	span, err := createSpan(ctx, tx.db.tracer, tx.db.opts, "query")
+	rows, span, err := tx.queryWithSpan(ctx, query, args)
 	if err != nil {
 		return nil, err
 	}
-	defer span.End()
+	span.End()
+	return rows, nil
+}
 
-	if tx.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if tx.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
+// TracedQuery support trace, refer to TracedQueryContext
+func (tx *Tx) TracedQuery(query string, args ...interface{}) (*Rows, error) {
+	return tx.TracedQueryContext(tx.ctx, query, args...)
+}
+
+// TracedQueryContext support trace like QueryContext, but the span of query ends when the
+// returned rows are closed or iterated to the end, the number of rows scanned and the
+// error of iteration are reported
+func (tx *Tx) TracedQueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, span, err := tx.queryWithSpan(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
+	return newRows(rows, span), nil
+}
+
+// queryWithSpan returns the rows with the span of query, the span is ended on error
+func (tx *Tx) queryWithSpan(ctx context.Context, query string, args []interface{}) (*sql.Rows, go2sky.Span, error) {
+	span := createQuerySpan(tx.spanContext(ctx), tx.db.tracer, tx.db.opts, "query", query)
+
+	tagQuery(span, tx.db.opts, query, args)
 
+	start := time.Now()
 	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, tx.db.opts, query, start)
 	if err != nil {
 		span.Error(time.Now(), err.Error())
+		span.End()
+		return nil, nil, err
 	}
-	return rows, err
+	return rows, span, nil
 }
 
 // QueryRow support trace
 func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
-	return tx.QueryRowContext(tx.ctx, query, args)
+	return tx.QueryRowContext(tx.ctx, query, args...)
 }
 
 // QueryRowContext support trace
 func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	if id := go2sky.SpanID(ctx); id == go2sky.EmptySpanID {
-		// if ctx do not contain parent span, use transaction ctx instead
-		ctx = tx.ctx
-	}
-	span, err := createSpan(ctx, tx.db.tracer, tx.db.opts, "query")
-	if err != nil {
-		return nil
-	}
+	span := createQuerySpan(tx.spanContext(ctx), tx.db.tracer, tx.db.opts, "query", query)
 	defer span.End()
 
-	if tx.db.opts.reportQuery {
-		span.Tag(go2sky.TagDBStatement, query)
-	}
-	if tx.db.opts.reportParam {
-		span.Tag(go2sky.TagDBSqlParameters, argsToString(args))
-	}
+	tagQuery(span, tx.db.opts, query, args)
 
-	return tx.Tx.QueryRowContext(ctx, query, args...)
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	tagSlowQuery(ctx, span, tx.db.opts, query, start)
+	return row
 }
@@ -0,0 +1,228 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+func TestTx(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithQueryReport())
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET name = ?", "foo"); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+		if _, err := tx.Exec("UPDATE users SET age = ?", 1); err != nil {
+			t.Errorf("exec error: %v", err)
+		}
+		rows, err := tx.Query("SELECT id FROM users WHERE id = ?", 1)
+		if err != nil {
+			t.Errorf("query error: %v", err)
+		} else {
+			rows.Close()
+		}
+		var id int
+		if err := tx.QueryRow("SELECT id FROM users WHERE id = ?", 1).Scan(&id); err != nil {
+			t.Errorf("query row error: %v", err)
+		}
+		stmt, err := tx.PrepareContext(ctx, "DELETE FROM users")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			t.Errorf("stmt exec error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("commit error: %v", err)
+		}
+		if err := tx.Rollback(); err != sql.ErrTxDone {
+			t.Errorf("rollback error = %v, want %v", err, sql.ErrTxDone)
+		}
+	})
+
+	var names []string
+	for _, s := range spans {
+		names = append(names, strings.TrimPrefix(s.OperationName(), "Mysql/Go2Sky/"))
+	}
+	want := "begin,execute,execute,query,query,execute,commit,rollback"
+	if strings.Join(names, ",") != want {
+		t.Errorf("spans = %v, want %s", names, want)
+	}
+	if !findSpan(spans, "Mysql/Go2Sky/rollback").IsError() {
+		t.Error("rollback after commit should be error")
+	}
+}
+
+func TestTransactionSpan(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithTransactionSpan())
+	defer db.Close()
+
+	tests := []struct {
+		name    string
+		query   string
+		commit  bool
+		outcome string
+		isError bool
+	}{
+		{name: "commit", query: "UPDATE users SET age = 1", commit: true, outcome: "commit"},
+		{name: "rollback", query: "UPDATE users SET age = 1", outcome: "rollback"},
+		{name: "commit error", query: "fail commit", commit: true, outcome: "commit", isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := trace(t, tracer, r, func(ctx context.Context) {
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					t.Fatalf("begin error: %v", err)
+				}
+				defer tx.Rollback()
+				if _, err := tx.ExecContext(ctx, tt.query); err != nil {
+					t.Errorf("exec error: %v", err)
+				}
+				if tt.commit {
+					if err := tx.Commit(); (err != nil) != tt.isError {
+						t.Errorf("commit error = %v, want error %v", err, tt.isError)
+					}
+				}
+			})
+
+			txSpan := findSpan(spans, "Mysql/Go2Sky/transaction")
+			if txSpan == nil {
+				t.Fatal("transaction span is not reported")
+			}
+			if txSpan.SpanType() != agentv3.SpanType_Local {
+				t.Errorf("transaction span type = %v, want Local", txSpan.SpanType())
+			}
+			if txSpan.IsError() != tt.isError {
+				t.Errorf("transaction span is error = %v, want %v", txSpan.IsError(), tt.isError)
+			}
+			if outcome := spanTags(txSpan)["db.transaction.outcome"]; outcome != tt.outcome {
+				t.Errorf("outcome = %s, want %s", outcome, tt.outcome)
+			}
+			for _, s := range spans {
+				if s == txSpan {
+					continue
+				}
+				if s.Context().ParentSpanID != txSpan.Context().SpanID {
+					t.Errorf("%s is not the child of transaction span", s.OperationName())
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionSpanBeginError(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithTransactionSpan())
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		if _, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != errFake {
+			t.Errorf("begin error = %v, want %v", err, errFake)
+		}
+	})
+	if len(spans) != 2 {
+		t.Fatalf("want begin and transaction spans, got %d", len(spans))
+	}
+	for _, s := range spans {
+		if !s.IsError() {
+			t.Errorf("%s should be error", s.OperationName())
+		}
+	}
+	if outcome := spanTags(findSpan(spans, "Mysql/Go2Sky/transaction"))["db.transaction.outcome"]; outcome != "begin" {
+		t.Errorf("outcome = %s, want begin", outcome)
+	}
+}
+
+func TestTransactionSpanOfStatements(t *testing.T) {
+	tracer, r := newTestTracer(t)
+	db := openFakeDB(t, tracer, WithTransactionSpan())
+	defer db.Close()
+
+	spans := trace(t, tracer, r, func(ctx context.Context) {
+		stmt, err := db.PrepareContext(ctx, "DELETE FROM users")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		defer stmt.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("conn error: %v", err)
+		}
+		defer conn.Close()
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin error: %v", err)
+		}
+		// the statements in the transaction are children of the transaction span
+		if _, err := tx.StmtContext(ctx, stmt).ExecContext(ctx); err != nil {
+			t.Errorf("stmt exec error: %v", err)
+		}
+		txStmt, err := tx.PrepareContext(ctx, "SELECT id FROM users")
+		if err != nil {
+			t.Fatalf("prepare error: %v", err)
+		}
+		var id int
+		if err := txStmt.QueryRowContext(ctx).Scan(&id); err != nil {
+			t.Errorf("stmt query row error: %v", err)
+		}
+		rows, err := tx.TracedQueryContext(ctx, "SELECT id FROM users")
+		if err != nil {
+			t.Fatalf("query error: %v", err)
+		}
+		rows.Close()
+		if err := tx.Rollback(); err != nil {
+			t.Errorf("rollback error: %v", err)
+		}
+	})
+
+	txSpan := findSpan(spans, "Mysql/Go2Sky/transaction")
+	if txSpan == nil {
+		t.Fatal("transaction span is not reported")
+	}
+	var names []string
+	for _, s := range spans {
+		if s == txSpan {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(s.OperationName(), "Mysql/Go2Sky/"))
+		if s.Context().ParentSpanID != txSpan.Context().SpanID {
+			t.Errorf("%s is not the child of transaction span", s.OperationName())
+		}
+	}
+	want := "begin,execute,query,query,rollback"
+	if strings.Join(names, ",") != want {
+		t.Errorf("spans = %v, want %s", names, want)
+	}
+	if outcome := spanTags(txSpan)["db.transaction.outcome"]; outcome != "rollback" {
+		t.Errorf("outcome = %s, want rollback", outcome)
+	}
+}
@@ -17,9 +17,11 @@ package zap_test
 
 import (
 	"context"
+	"fmt"
 
 	zapplugin "github.com/SkyAPM/go2sky-plugins/zap"
 	"go.uber.org/zap"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
 )
 
 func ExampleTraceContext() {
@@ -39,3 +41,35 @@ func ExampleWrapWithContext() {
 	// Output:
 	// {"level":"info","msg":"test","SW_CTX":"[,,N/A,N/A,-1]"}
 }
+
+type printSender struct{}
+
+func (printSender) SendLog(ctx context.Context, logData *logv3.LogData) {
+	fmt.Print(logData.GetBody().GetText().GetText())
+	for _, tag := range logData.GetTags().GetData() {
+		fmt.Printf(" %s=%s", tag.Key, tag.Value)
+	}
+	fmt.Println()
+}
+
+func ExampleWrapLogger_WithLogSender() {
+	ctx := context.Background()
+
+	// report logging to SkyWalking, eg: by the kafka reporter
+	logger := zapplugin.WrapWithContext(zap.NewExample()).WithLogSender(printSender{})
+	logger.Info(ctx, "test", zap.String("foo", "bar"))
+	// Output:
+	// test level=info foo=bar
+	// {"level":"info","msg":"test","foo":"bar","SW_CTX":"[,,N/A,N/A,-1]"}
+}
+
+func ExampleWrapLogger_With() {
+	ctx := context.Background()
+
+	// the fields added by With are reported too
+	logger := zapplugin.WrapWithContext(zap.NewExample()).WithLogSender(printSender{})
+	logger.With(zap.String("module", "order")).Info(ctx, "test", zap.String("foo", "bar"))
+	// Output:
+	// test level=info foo=bar module=order
+	// {"level":"info","msg":"test","module":"order","foo":"bar","SW_CTX":"[,,N/A,N/A,-1]"}
+}
@@ -6,4 +6,5 @@ require (
 	github.com/SkyAPM/go2sky v1.5.0
 	go.uber.org/zap v1.16.0
 	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f
+	skywalking.apache.org/repo/goapi v0.0.0-20220401015832-2c9eee9481eb
 )
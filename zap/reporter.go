@@ -0,0 +1,80 @@
+//
+// Copyright 2022 SkyAPM org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package zap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	commonv3 "skywalking.apache.org/repo/goapi/collect/common/v3"
+	logv3 "skywalking.apache.org/repo/goapi/collect/logging/v3"
+)
+
+// LogSender send log data to SkyWalking, eg: the kafka reporter
+type LogSender interface {
+	SendLog(ctx context.Context, logData *logv3.LogData)
+}
+
+func (log *WrapLogger) report(ctx context.Context, lvl zapcore.Level, msg string, fields []zap.Field) {
+	if log.sender == nil || !log.base.Core().Enabled(lvl) {
+		return
+	}
+
+	// the fields added by With are kept in the core of base, encode them again
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range log.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]*commonv3.KeyStringValuePair, 0, len(keys)+1)
+	tags = append(tags, &commonv3.KeyStringValuePair{
+		Key:   "level",
+		Value: lvl.String(),
+	})
+	for _, k := range keys {
+		tags = append(tags, &commonv3.KeyStringValuePair{
+			Key:   k,
+			Value: fmt.Sprintf("%v", enc.Fields[k]),
+		})
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	log.sender.SendLog(ctx, &logv3.LogData{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Body: &logv3.LogDataBody{
+			Type: "text",
+			Content: &logv3.LogDataBody_Text{
+				Text: &logv3.TextLog{Text: msg},
+			},
+		},
+		Tags: &logv3.LogTags{Data: tags},
+	})
+}
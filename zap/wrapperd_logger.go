@@ -25,27 +25,38 @@ import (
 
 // WrapLogger is wrap the zap logger, also contains all method at zap logger, correlate the context before logging
 type WrapLogger struct {
-	base *zap.Logger
+	base   *zap.Logger
+	sender LogSender
+	// fields are added by With, they are reported with the fields of every log
+	fields []zap.Field
 }
 
 // WrapWithContext original zap logger(not sugar)
 func WrapWithContext(logger *zap.Logger) *WrapLogger {
-	return &WrapLogger{logger}
+	return &WrapLogger{base: logger}
+}
+
+// WithLogSender report the logging to SkyWalking by sender, eg: the kafka reporter
+func (log *WrapLogger) WithLogSender(sender LogSender) *WrapLogger {
+	return &WrapLogger{base: log.base, sender: sender, fields: log.fields}
 }
 
 // Named wrap the zap logging function
 func (log *WrapLogger) Named(s string) *WrapLogger {
-	return WrapWithContext(log.base.Named(s))
+	return &WrapLogger{base: log.base.Named(s), sender: log.sender, fields: log.fields}
 }
 
 // WithOptions wrap the zap logging function
 func (log *WrapLogger) WithOptions(opts ...zap.Option) *WrapLogger {
-	return WrapWithContext(log.base.WithOptions(opts...))
+	return &WrapLogger{base: log.base.WithOptions(opts...), sender: log.sender, fields: log.fields}
 }
 
 // With wrap the zap logging function
 func (log *WrapLogger) With(fields ...zap.Field) *WrapLogger {
-	return WrapWithContext(log.base.With(fields...))
+	withFields := make([]zap.Field, 0, len(log.fields)+len(fields))
+	withFields = append(withFields, log.fields...)
+	withFields = append(withFields, fields...)
+	return &WrapLogger{base: log.base.With(fields...), sender: log.sender, fields: withFields}
 }
 
 // Check wrap the zap logging function
@@ -55,42 +66,49 @@ func (log *WrapLogger) Check(lvl zapcore.Level, msg string) *zapcore.CheckedEntr
 
 // Debug wrap the zap logging function and relate the context
 func (log *WrapLogger) Debug(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.DebugLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Debug(msg, fields...)
 }
 
 // Info wrap the zap logging function and relate the context
 func (log *WrapLogger) Info(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.InfoLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Info(msg, fields...)
 }
 
 // Warn wrap the zap logging function and relate the context
 func (log *WrapLogger) Warn(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.WarnLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Warn(msg, fields...)
 }
 
 // Error wrap the zap logging function and relate the context
 func (log *WrapLogger) Error(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.ErrorLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Error(msg, fields...)
 }
 
 // DPanic wrap the zap logging function and relate the context
 func (log *WrapLogger) DPanic(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.DPanicLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.DPanic(msg, fields...)
 }
 
 // Panic wrap the zap logging function and relate the context
 func (log *WrapLogger) Panic(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.PanicLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Panic(msg, fields...)
 }
 
 // Fatal wrap the zap logging function and relate the context
 func (log *WrapLogger) Fatal(ctx context.Context, msg string, fields ...zap.Field) {
+	log.report(ctx, zapcore.FatalLevel, msg, fields)
 	log.appendContextField(ctx, &fields)
 	log.base.Fatal(msg, fields...)
 }